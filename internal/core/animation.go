@@ -0,0 +1,77 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+var (
+	ANIMATED_IMAGE_FORMATS = []string{"avif", "webp"}
+)
+
+// createAnimatedOutputs converts an animated GIF/APNG into animated variants of the profile's widths and formats, vf lays it out into w×h,
+// or into a muted MP4 of w×h, keeping its loop count. Formats that can't be animated are skipped
+func createAnimatedOutputs(v *Task, filename, vf string, w, h int, opt *Options) error {
+	loop := v.MediaInfo.LoopCount
+	if opt.Profile.Animation == ANIMATION_MP4 {
+		mp4 := filepath.Join(AppDir, filename+".mp4")
+		e := ffmpegx.CompressAnimatedMP4(mp4, v.Origin, vf)
+		if e != nil {
+			log.Println(e)
+			return e
+		}
+		v.OutputFiles = append(v.OutputFiles, filename+".mp4")
+		v.PublicUrl = PUBLIC_PREFIX + filename + ".mp4"
+		return nil
+	}
+
+	formats := []string{}
+	for _, format := range opt.Profile.Formats {
+		if contains(ANIMATED_IMAGE_FORMATS, format) {
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		formats = DEFAULT_IMAGE_FORMATS
+	}
+	for _, width := range variantWidths(opt.Profile.Widths, w) {
+		height := h
+		vfVariant := vf
+		if width != w {
+			height = ffmpegx.Even(width * h / w)
+			vfVariant = fmt.Sprintf("%s,scale=%d:%d", vf, width, height)
+		}
+		filename := fmt.Sprintf("%s@%dx%d", v.Id, width, height)
+		for _, format := range formats {
+			enc := opt.ImageEncoding(format)
+			dst := filepath.Join(AppDir, filename+"."+format)
+			e := ffmpegx.CompressAnimated(dst, v.Origin, vfVariant, loop, &enc)
+			if e != nil {
+				log.Println(e)
+				return e
+			}
+			info, e := os.Stat(dst)
+			if e != nil {
+				log.Println(e)
+				return e
+			}
+			v.OutputFiles = append(v.OutputFiles, filename+"."+format)
+			v.Variants = append(v.Variants, Variant{
+				Width:  width,
+				Height: height,
+				Format: format,
+				Size:   info.Size(),
+				Url:    PUBLIC_PREFIX + filename + "." + format,
+
+				Encoding: enc,
+			})
+		}
+	}
+	// the full size one of the first format
+	v.PublicUrl = v.Variants[len(v.Variants)-len(formats)].Url
+	return nil
+}
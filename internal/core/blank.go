@@ -0,0 +1,39 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+var (
+	ErrBlankMedia = errors.New("mostly black or silent media")
+)
+
+// analyzeBlank records the black frames and silence of a video, rejects it over the thresholds of opt,
+// and clips leading and trailing silence off if asked, the intervals stay relative to the clip submitted
+func (v *Task) analyzeBlank(opt ffmpegx.BlankOptions) error {
+	e := v.MediaInfo.DetectBlank(v.Origin, v.Clip.StartAt())
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	if reason := opt.Exceeded(v.MediaInfo); reason != "" {
+		os.Remove(v.Origin)
+		return fmt.Errorf("%w: %s", ErrBlankMedia, reason)
+	}
+	if !opt.TrimSilence {
+		return nil
+	}
+	start, end, ok := v.MediaInfo.SilenceTrim()
+	if !ok {
+		return nil
+	}
+	// a new clip, since the submitted one is shared with the options
+	v.Clip = &ffmpegx.Clip{Start: v.Clip.StartAt() + start, Duration: end - start}
+	v.MediaInfo.DurationSeconds = int(end - start)
+	return nil
+}
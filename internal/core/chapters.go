@@ -0,0 +1,76 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+// chapterStep returns the step splitting the video into chapters at scene changes, with a thumbnail of each laid out by vf.
+// It runs in background before the encodes, which read the FFMETADATA file at meta, empty if the profile doesn't write it
+func (v *Task) chapterStep(filename, vf string, opt ffmpegx.ChapterOptions) (meta string, step func() error) {
+	opt = opt.WithDefaults()
+	if opt.Metadata {
+		meta = filepath.Join(AppDir, filename+".chapters.txt")
+	}
+	p, origin, start, enc := v.pipeline, v.Origin, v.Clip.StartAt(), v.CoverEncoding
+	duration := float64(v.MediaInfo.DurationSeconds)
+	return meta, func() error {
+		scenes, e := ffmpegx.DetectScenes(p.ctx, origin, start, math.Max(duration, 1), opt.Threshold)
+		if p.isCanceled() {
+			return errCanceled
+		}
+		if e != nil {
+			log.Println(e)
+			return fmt.Errorf("detect chapters failed:%w", e)
+		}
+		chapters := opt.Chapters(scenes, duration)
+		files := []string{}
+		for i := range chapters {
+			if p.isCanceled() {
+				removeFiles(files)
+				return errCanceled
+			}
+			thumbnail := fmt.Sprintf("%s.chapter%d.avif", filename, i+1)
+			e = ffmpegx.CreateCoverAt(filepath.Join(AppDir, thumbnail), origin, start+chapters[i].Start, vf, enc)
+			if e != nil {
+				log.Println(e)
+				removeFiles(append(files, thumbnail))
+				return fmt.Errorf("create chapter thumbnails failed:%w", e)
+			}
+			files = append(files, thumbnail)
+			chapters[i].Thumbnail = PUBLIC_PREFIX + thumbnail
+		}
+
+		track := ""
+		if opt.VTT {
+			vtt := filename + ".chapters.vtt"
+			e = ffmpegx.WriteChapterVTT(filepath.Join(AppDir, vtt), chapters)
+			if e != nil {
+				log.Println(e)
+				removeFiles(append(files, vtt))
+				return fmt.Errorf("write chapters failed:%w", e)
+			}
+			files = append(files, vtt)
+			track = PUBLIC_PREFIX + vtt
+		}
+		if meta != "" {
+			e = ffmpegx.WriteChapterMetadata(meta, chapters)
+			if e != nil {
+				log.Println(e)
+				removeFiles(append(files, meta))
+				return fmt.Errorf("write chapters failed:%w", e)
+			}
+			files = append(files, meta)
+		}
+		if !p.commit(files, func(t *Task) {
+			t.Chapters, t.ChaptersTrack = chapters, track
+		}) {
+			return errCanceled
+		}
+		return nil
+	}
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"mime/multipart"
+	"path/filepath"
+	"time"
+
+	"github.com/StevenZack/tools/strToolkit"
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+	"github.com/StevenZack/transcoder/internal/tools"
+)
+
+// CreateConcatTask joins video clips and images, in the order of fhs, into one video task.
+// Every segment is normalized to the output size of the first one, HDR segments are always tone-mapped.
+func CreateConcatTask(fhs []*multipart.FileHeader, user string, opt *Options) (*Task, error) {
+	if len(fhs) < 2 {
+		return nil, fmt.Errorf("%w: concat needs at least 2 files", ErrInvalidOptions)
+	}
+	if p := opt.Profile; p.Sprite != nil || p.Preview != nil || p.Chapters != nil || p.Blank != nil || p.Quality || p.TargetVMAF > 0 {
+		return nil, fmt.Errorf("%w: sprite, preview, chapters, blank, quality and targetVmaf of profile %s aren't supported in concat mode", ErrInvalidOptions, p.Name)
+	}
+	v := &Task{
+		Id:       tools.GenerateID(),
+		Ext:      ".mp4",
+		Mime:     "video/mp4",
+		User:     user,
+		Profile:  opt.Profile.Name,
+		CreateAt: time.Now().Format(time.RFC3339),
+	}
+
+	segs := []ffmpegx.Segment{}
+	infos := []*ffmpegx.MediaInfo{}
+	var total float64
+	for i, fh := range fhs {
+		ext := filepath.Ext(fh.Filename)
+		seg := ffmpegx.Segment{
+			Filename: filepath.Join(AppDir, fmt.Sprintf("%s.%d%s", v.Id, i, ext)),
+		}
+		v.Inputs = append(v.Inputs, seg.Filename)
+		if i == 0 {
+			v.Origin = seg.Filename
+		}
+		_, e := tools.ReadFileHeader(seg.Filename, fh)
+		if e != nil {
+			log.Println(e)
+			v.Clean()
+			return nil, e
+		}
+		filename, t, _, e := detectUpload(seg.Filename, fh.Filename)
+		if e != nil {
+			v.Clean()
+			return nil, e
+		}
+		v.Inputs[len(v.Inputs)-1] = filename
+		if isHEIF(t.Mime) {
+			// decoded into a PNG ffmpeg reads, the upload is kept as an input
+			filename, _, _, e = decodeHEIF(filename)
+			if e != nil {
+				v.Clean()
+				return nil, e
+			}
+			v.Inputs = append(v.Inputs, filename)
+		}
+		kind := strToolkit.SubBefore(t.Mime, "/", "")
+		if isDocument(t.Mime) {
+			// the first page of documents is shown like an image
+			filename, _, e = rasterize(filename, t.Mime, 1, opt.Profile.Document)
+			if e != nil {
+				v.Clean()
+				return nil, e
+			}
+			v.Inputs = append(v.Inputs, filename)
+			kind = "image"
+		}
+		seg.Filename = filename
+		if i == 0 {
+			v.Origin = filename
+		}
+		info, e := ffmpegx.ProbeMedia(seg.Filename)
+		if e != nil {
+			log.Println(e)
+			v.Clean()
+			return nil, e
+		}
+
+		switch kind {
+		case "image":
+			seg.Image = true
+			seg.Duration = opt.ImageDuration(i)
+		case "video":
+			seg.Duration = float64(info.DurationSeconds)
+			seg.HasAudio = info.HasAudio
+			if info.IsHDR() && !ffmpegx.CanTonemap() {
+				v.Clean()
+				return nil, unsupportedHDR(fh.Filename)
+			}
+			if opt.Profile.Deinterlace != "" {
+				info.FieldOrder, e = ffmpegx.DetectFieldOrder(seg.Filename, 0)
+				if e != nil {
+					log.Println(e)
+					v.Clean()
+					return nil, e
+				}
+			}
+		default:
+			v.Clean()
+			return nil, fmt.Errorf("%w: unsupported file type of %s", ErrUnsupportedMedia, fh.Filename)
+		}
+		total += seg.Duration
+		segs = append(segs, seg)
+		infos = append(infos, info)
+	}
+
+	layout := opt.Layout()
+	hdr := false
+	// filters normalizing every segment into the output size of the first one
+	segFilters := func(widthConstraint, heightConstraint int) ([]string, int, int) {
+		vfs := []string{}
+		_, w, h := layout.Filter(widthConstraint, heightConstraint, infos[0].Width, infos[0].Height)
+		for _, info := range infos {
+			vf, _, _ := layout.Filter(widthConstraint, heightConstraint, info.Width, info.Height)
+			vf = ffmpegx.PadTo(vf, w, h)
+			if info.IsInterlaced() {
+				vf = ffmpegx.DeinterlaceFilter(opt.Profile.Deinterlace, info.FieldOrder) + "," + vf
+			}
+			if info.IsHDR() {
+				hdr = true
+				vf += "," + ffmpegx.TONEMAP_FILTER
+			}
+			vfs = append(vfs, vf)
+		}
+		return vfs, w, h
+	}
+	vfs, w, h := segFilters(ffmpegx.MAX_AV1_CONSTRAINT, ffmpegx.MAX_AV1_CONSTRAINT)
+	vfsHEVC, wHEVC, hHEVC := segFilters(ffmpegx.MAX_HEVC_CONSTRAINT, ffmpegx.MAX_HEVC_CONSTRAINT)
+	var colorArgs []string
+	if hdr {
+		colorArgs = ffmpegx.SDR_COLOR_ARGS
+	}
+	v.MediaInfo = &ffmpegx.MediaInfo{
+		Width:           wHEVC,
+		Height:          hHEVC,
+		CodedWidth:      wHEVC,
+		CodedHeight:     hHEVC,
+		DurationSeconds: int(total),
+		HasAudio:        true,
+	}
+
+	filename := fmt.Sprintf("%s@%dx%d", v.Id, w, h)
+	v.ProgressFile = filepath.Join(AppDir, filename+".progress.txt")
+	// cover
+	var start float64
+	for i, seg := range segs {
+		v.segments = append(v.segments, coverSegment{seg, start, vfs[i]})
+		start += seg.Duration
+	}
+	e := v.createCover(filename+".cover.avif", vfs[0], opt.CoverOptions(), opt.ImageEncoding("avif"))
+	if e != nil {
+		log.Println(e)
+		v.Clean()
+		return nil, e
+	}
+	v.OutputFiles = append(v.OutputFiles, filename+".cover.avif")
+	v.setCoverPlaceholders()
+
+	// video
+	av1 := filepath.Join(AppDir, filename+".av1.mp4")
+	hevc := filepath.Join(AppDir, filename+".hevc.mp4")
+	input := ffmpegx.ConcatInputArgs(segs)
+	v.pipeline = newPipeline()
+	ffmpegx.CompressToAV1_HEVC(v.pipeline.ctx, av1, hevc, v.ProgressFile, input, ffmpegx.ConcatFilterArgs(segs, vfs), ffmpegx.ConcatFilterArgs(segs, vfsHEVC), colorArgs, nil, v.pipeline.end)
+
+	v.OutputFiles = append(v.OutputFiles, av1, hevc)
+	v.PublicUrl = PUBLIC_PREFIX + filename + ".av1.mp4"
+	acquire(v.files()...)
+	return v, nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"log"
+	"math"
+	"path/filepath"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+// createCover writes the cover of a video, vf lays the frame out, enc is kept for chapter thumbnails and regenerated covers
+func (t *Task) createCover(filename, vf string, opt *ffmpegx.CoverOptions, enc ffmpegx.ImageEncoding) error {
+	t.CoverFilter = vf
+	t.CoverEncoding = &enc
+	t.Cover = PUBLIC_PREFIX + filename
+	dst := filepath.Join(AppDir, filename)
+	switch opt.Mode {
+	case ffmpegx.COVER_TIME, ffmpegx.COVER_PERCENT:
+		return t.createCoverAt(opt.Offset(t.MediaInfo.DurationSeconds))
+	case ffmpegx.COVER_AUTO:
+		length := math.Min(ffmpegx.AUTO_COVER_WINDOW, float64(t.MediaInfo.DurationSeconds))
+		e := ffmpegx.CreateAutoCover(dst, t.Origin, t.Clip.StartAt(), length, vf, t.CoverEncoding)
+		if e == nil {
+			return nil
+		}
+		// all frames may be black or blurry
+		log.Println(e)
+	}
+	return ffmpegx.CreateCoverOfVideo(dst, t.Origin, t.Clip, vf, t.CoverEncoding)
+}
+
+// RegenerateCover replaces the cover and its placeholders with the frame at seconds, relative to the clip if any
+func (t *Task) RegenerateCover(at float64) error {
+	t.unshareCover()
+	e := t.createCoverAt(at)
+	if e != nil {
+		return e
+	}
+	// placeholders are optional
+	t.setCoverPlaceholders()
+	return nil
+}
+
+// coverSegment is an input of a concat task, covers at a time are taken from the one shown then
+type coverSegment struct {
+	ffmpegx.Segment
+	start float64 // seconds into the output
+	vf    string
+}
+
+func (t *Task) createCoverAt(at float64) error {
+	dst := filepath.Join(AppDir, filepath.Base(t.Cover))
+	filename, vf, offset := t.Origin, t.CoverFilter, t.Clip.StartAt()+at
+	for _, seg := range t.segments {
+		if at < seg.start {
+			break
+		}
+		filename, vf, offset = seg.Filename, seg.vf, at-seg.start
+		if seg.Image {
+			// images are a single frame
+			offset = 0
+		}
+	}
+	return ffmpegx.CreateCoverAt(dst, filename, offset, vf, t.CoverEncoding)
+}
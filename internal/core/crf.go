@@ -0,0 +1,44 @@
+package core
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+// crfStep returns the step picking the CRF of each video output for the target VMAF, it encodes samples,
+// so it runs in background before the outputs, the searches are recorded on the task once both are done
+func (t *Task) crfStep(filename, vf, vfHEVC string, colorArgs []string, target float64) func() (ffmpegx.VideoCRF, error) {
+	pipe := t.pipeline
+	sample := filepath.Join(AppDir, filename)
+	origin, start, duration := t.Origin, t.Clip.StartAt(), float64(t.MediaInfo.DurationSeconds)
+	return func() (ffmpegx.VideoCRF, error) {
+		crf := ffmpegx.VideoCRF{}
+		outputs := []struct {
+			codec string
+			vf    string
+			crf   *int
+		}{
+			{ffmpegx.CODEC_AV1, vf, &crf.AV1},
+			{ffmpegx.CODEC_HEVC, vfHEVC, &crf.HEVC},
+		}
+		searches := []*ffmpegx.CRFSearch{}
+		for _, output := range outputs {
+			if pipe.isCanceled() {
+				return crf, errCanceled
+			}
+			search, e := ffmpegx.SearchCRF(pipe.ctx, sample, origin, start, duration, output.vf, output.codec, target, colorArgs)
+			if e != nil {
+				log.Println(e)
+				return crf, e
+			}
+			*output.crf = search.CRF
+			searches = append(searches, search)
+		}
+		if !pipe.commit(nil, func(t *Task) { t.CRFSearch = searches }) {
+			return crf, errCanceled
+		}
+		return crf, nil
+	}
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+var (
+	refsLock sync.Mutex
+	refs     = map[string]int{} // file => number of tasks using it
+)
+
+// acquire marks files as used by one more task
+func acquire(files ...string) {
+	refsLock.Lock()
+	defer refsLock.Unlock()
+	for _, f := range files {
+		refs[f]++
+	}
+}
+
+// release marks files as unused by one task, returning those no task uses anymore, files never acquired included
+func release(files ...string) []string {
+	refsLock.Lock()
+	defer refsLock.Unlock()
+	unused := []string{}
+	for _, f := range files {
+		refs[f]--
+		if refs[f] <= 0 {
+			delete(refs, f)
+			unused = append(unused, f)
+		}
+	}
+	return unused
+}
+
+func shared(file string) bool {
+	refsLock.Lock()
+	defer refsLock.Unlock()
+	return refs[file] > 1
+}
+
+// files returns the path of every file the task keeps on disk
+func (t *Task) files() []string {
+	files := append([]string{}, t.Inputs...)
+	// Origin of a concat task is one of its inputs
+	if !contains(files, t.Origin) {
+		files = append(files, t.Origin)
+	}
+	if t.ProgressFile != "" {
+		files = append(files, t.ProgressFile)
+	}
+	if t.QualityFile != "" {
+		files = append(files, t.QualityFile)
+	}
+	files = append(files, t.OutputFiles...)
+	for i, f := range files {
+		files[i] = appPath(f)
+	}
+	return files
+}
+
+// appPath returns the path of a file in AppDir, most outputs are listed by file name
+func appPath(f string) string {
+	if filepath.IsAbs(f) {
+		return f
+	}
+	return filepath.Join(AppDir, f)
+}
+
+// succeeded reports whether the task has finished with all outputs written
+func (t *Task) succeeded() bool {
+	t.LoadProgress()
+	return t.IsEnded && t.Error == ""
+}
+
+// findDuplicate returns a succeeded task of user of the same upload content made with the same options,
+// tasks of other users aren't exposed
+func findDuplicate(hash, optionsKey, user string) *Task {
+	var found *Task
+	TaskMap.Range(func(key string, value Task) bool {
+		if value.User == user && value.Hash == hash && value.OptionsKey == optionsKey && value.succeeded() {
+			found = &value
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// reuse returns a copy of t for v, sharing t's files instead of encoding them again
+func (t *Task) reuse(v *Task) *Task {
+	dup := *t
+	dup.Id = v.Id
+	dup.User = v.User
+	dup.CreateAt = v.CreateAt
+	// t's background work is over, cleaning the copy mustn't cancel it on t
+	dup.pipeline = newPipeline()
+	dup.pipeline.end(nil)
+	dup.segments = append([]coverSegment(nil), t.segments...)
+	dup.DuplicateOf = t.Id
+	dup.Inputs = append([]string(nil), t.Inputs...)
+	dup.OutputFiles = append([]string(nil), t.OutputFiles...)
+	acquire(dup.files()...)
+	return &dup
+}
+
+// unshareCover moves the task onto a cover of its own before it's regenerated, so tasks sharing the old one keep it
+func (t *Task) unshareCover() {
+	filename := filepath.Base(t.Cover)
+	// covers are kept in OutputFiles by file name
+	if !shared(appPath(filename)) {
+		return
+	}
+	own := t.Id + ".cover.avif"
+	for i, output := range t.OutputFiles {
+		if output == filename {
+			t.OutputFiles[i] = own
+		}
+	}
+	release(appPath(filename))
+	acquire(appPath(own))
+	t.Cover = PUBLIC_PREFIX + own
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
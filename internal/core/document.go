@@ -0,0 +1,57 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/StevenZack/transcoder/internal/raster"
+	"github.com/StevenZack/transcoder/internal/sniff"
+)
+
+func isDocument(mime string) bool {
+	return mime == sniff.PDF.Mime || mime == sniff.SVG.Mime
+}
+
+// rasterize renders a page of a PDF, 1-based and 1 if 0, or an SVG into a PNG next to it, which the image pipeline reads instead.
+// Returns the PNG and the page count, 1 for SVG
+func rasterize(filename, mime string, page int, opt raster.Options) (string, int, error) {
+	dst := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".page.png"
+	opt = opt.WithDefaults()
+	if mime == sniff.SVG.Mime {
+		e := raster.RasterizeSVG(dst, filename, opt)
+		if e != nil {
+			log.Println(e)
+			return "", 0, unsupportedDocument(filename, e)
+		}
+		return dst, 1, nil
+	}
+
+	if page == 0 {
+		page = 1
+	}
+	pages, w, h, e := raster.ProbePDF(filename, page)
+	if e != nil {
+		log.Println(e)
+		return "", 0, unsupportedDocument(filename, e)
+	}
+	if page > pages {
+		return "", 0, fmt.Errorf("%w: page %d exceeds the %d pages of %s", ErrInvalidOptions, page, pages, filepath.Base(filename))
+	}
+	e = raster.RasterizePDF(dst, filename, page, w, h, opt)
+	if e != nil {
+		log.Println(e)
+		return "", 0, unsupportedDocument(filename, e)
+	}
+	return dst, pages, nil
+}
+
+// unsupportedDocument reports documents that can't be rasterized as unsupported media, the server may lack the rasterizer
+func unsupportedDocument(filename string, e error) error {
+	if errors.Is(e, raster.ErrMissingTool) {
+		return fmt.Errorf("%w: %s can't be rasterized, %v", ErrUnsupportedMedia, filepath.Base(filename), e)
+	}
+	return fmt.Errorf("%w: %s can't be rasterized", ErrUnsupportedMedia, filepath.Base(filename))
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/StevenZack/transcoder/internal/exif"
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+	"github.com/StevenZack/transcoder/internal/heif"
+	"github.com/StevenZack/transcoder/internal/sniff"
+)
+
+const (
+	HEIF_COLLECTION_FPS = 1 // images of a HEIF collection have no timing, each one is shown a second
+)
+
+func isHEIF(mime string) bool {
+	return mime == sniff.HEIC.Mime || mime == sniff.HEIF.Mime || mime == sniff.AVIF.Mime
+}
+
+// decodeHEIF decodes a HEIF or AVIF file into a PNG next to it, or an APNG if it holds an image sequence or several images.
+// ffmpeg reads only some of them, tile grids in particular, so items are taken apart by ourselves.
+// Returns the decoded file, the EXIF, and whether the orientation is already applied, by irot/imir or the sequence track
+func decodeHEIF(filename string) (string, *exif.Metadata, bool, error) {
+	f, e := heif.ReadFile(filename)
+	if e != nil {
+		log.Println(e)
+		return "", nil, false, unsupportedHEIF(filename, e)
+	}
+	metadata, e := readHEIFMetadata(f)
+	if e != nil {
+		// broken EXIF doesn't matter, since it's stripped anyway
+		log.Println(e)
+	}
+
+	dst := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".decoded.png"
+	images := f.Images()
+	switch {
+	case f.HasSequence:
+		e = ffmpegx.DecodeSequence(dst, filename)
+		if e != nil {
+			log.Println(e)
+			return "", nil, false, e
+		}
+		return dst, metadata, true, nil
+	case len(images) == 0:
+		return "", nil, false, fmt.Errorf("%w: no image in %s", ErrUnsupportedMedia, filepath.Base(filename))
+	case len(images) == 1:
+		e = decodeHEIFImage(f, images[0], dst)
+		if e != nil {
+			return "", nil, false, unsupportedHEIF(filename, e)
+		}
+		return dst, metadata, images[0].Transform() != "", nil
+	}
+
+	// a collection, e.g. a burst, is joined as an animation
+	pattern := strings.TrimSuffix(dst, ".png") + ".%d.png"
+	defer func() {
+		for i := range images {
+			os.Remove(fmt.Sprintf(pattern, i+1))
+		}
+	}()
+	for i, it := range images {
+		e = decodeHEIFImage(f, it, fmt.Sprintf(pattern, i+1))
+		if e != nil {
+			return "", nil, false, unsupportedHEIF(filename, e)
+		}
+	}
+	// the primary image comes first, its size is the size of the animation
+	info, e := ffmpegx.ProbeMedia(fmt.Sprintf(pattern, 1))
+	if e != nil {
+		log.Println(e)
+		return "", nil, false, e
+	}
+	e = ffmpegx.JoinAPNG(dst, pattern, HEIF_COLLECTION_FPS, info.Width, info.Height)
+	if e != nil {
+		log.Println(e)
+		return "", nil, false, e
+	}
+	return dst, metadata, true, nil
+}
+
+// decodeHEIFImage decodes a coded or grid image into dst, with irot/imir applied
+func decodeHEIFImage(f *heif.File, it *heif.Item, dst string) error {
+	tiles, cols, rows, w, h := []*heif.Item{it}, 1, 1, 0, 0
+	if it.Type == "grid" {
+		var e error
+		tiles, cols, rows, w, h, e = f.Grid(it)
+		if e != nil {
+			log.Println(e)
+			return e
+		}
+	}
+	stream, format, e := f.Bitstream(tiles)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	src := dst + "." + format
+	e = os.WriteFile(src, stream, 0644)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	defer os.Remove(src)
+	e = ffmpegx.DecodeBitstream(dst, src, format, cols, rows, w, h, it.Transform())
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	return nil
+}
+
+// readHEIFMetadata reads the EXIF of the primary image, and whether it has an ICC profile
+func readHEIFMetadata(f *heif.File) (*exif.Metadata, error) {
+	var metadata *exif.Metadata
+	tiff, e := f.Exif()
+	if e == nil && tiff != nil {
+		metadata, e = exif.ParseTIFF(tiff)
+	}
+	if images := f.Images(); len(images) > 0 && images[0].HasICC() {
+		if metadata == nil {
+			metadata = new(exif.Metadata)
+		}
+		metadata.HasICC = true
+	}
+	return metadata, e
+}
+
+// unsupportedHEIF reports broken HEIF structures as unsupported media
+func unsupportedHEIF(filename string, e error) error {
+	if errors.Is(e, heif.ErrInvalid) {
+		return fmt.Errorf("%w: %s: %v", ErrUnsupportedMedia, filepath.Base(filename), e)
+	}
+	return e
+}
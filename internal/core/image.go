@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/StevenZack/transcoder/internal/exif"
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+// Variant is one encoded size and format of an image, for srcset and <picture>
+type Variant struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	Size   int64  `json:"size"` // bytes
+	Url    string `json:"url"`
+
+	Encoding ffmpegx.ImageEncoding `json:"encoding"` // settings it was made with
+}
+
+var (
+	DEFAULT_IMAGE_FORMATS = []string{"avif", "webp"}
+)
+
+// variantWidths returns the widths of the ladder narrower than w, plus w itself, ladder entries wider than w are never upscaled
+func variantWidths(ladder []int, w int) []int {
+	widths := []int{}
+	for _, width := range ladder {
+		if width > 0 && width < w {
+			widths = append(widths, ffmpegx.Even(width))
+		}
+	}
+	return append(widths, w)
+}
+
+// createImageVariants encodes every width of the profile's ladder in every format, vf lays the image out into w×h
+func (v *Task) createImageVariants(vf string, w, h int, opt *Options) error {
+	profile := opt.Profile
+	formats := profile.Formats
+	if len(formats) == 0 {
+		formats = DEFAULT_IMAGE_FORMATS
+	}
+	// JPEG sources can be recompressed into JPEG XL losslessly at full size, unless the layout or the orientation changes their pixels
+	layout := opt.Layout()
+	recompressible := v.Mime == "image/jpeg" && (layout.Mode == "" || layout.Mode == ffmpegx.LAYOUT_FIT) && layout.Crop == nil &&
+		v.Metadata.TransposeFilter() == "" && ffmpegx.HasProgram("cjxl")
+	for _, width := range variantWidths(profile.Widths, w) {
+		height := h
+		vfVariant := vf
+		if width != w {
+			height = ffmpegx.Even(width * h / w)
+			vfVariant = fmt.Sprintf("%s,scale=%d:%d", vf, width, height)
+		}
+		filename := fmt.Sprintf("%s@%dx%d", v.Id, width, height)
+		for _, format := range formats {
+			enc := opt.ImageEncoding(format)
+			dst := filepath.Join(AppDir, filename+"."+format)
+			var e error
+			variantWidth, variantHeight := width, height
+			// the full size one, w×h is rounded to even numbers, while the recompression keeps the original size
+			if format == "jxl" && recompressible && width == w {
+				enc = ffmpegx.ImageEncoding{Recompressed: true, Effort: enc.Effort}
+				e = v.recompressJPEG(dst, &enc)
+				variantWidth, variantHeight = v.MediaInfo.Width, v.MediaInfo.Height
+			} else {
+				e = ffmpegx.CompressImage(dst, v.Origin, vfVariant, &enc)
+			}
+			if e != nil {
+				log.Println(e)
+				return e
+			}
+			e = exif.CopyTags(dst, v.upload(), profile.KeepMetadata)
+			if e != nil {
+				// outputs are still stripped, which is safe
+				log.Println(e)
+			}
+			info, e := os.Stat(dst)
+			if e != nil {
+				log.Println(e)
+				return e
+			}
+			v.OutputFiles = append(v.OutputFiles, filename+"."+format)
+			v.Variants = append(v.Variants, Variant{
+				Width:  variantWidth,
+				Height: variantHeight,
+				Format: format,
+				Size:   info.Size(),
+				Url:    PUBLIC_PREFIX + filename + "." + format,
+
+				Encoding: enc,
+			})
+		}
+	}
+	// the full size one of the first format
+	v.PublicUrl = v.Variants[len(v.Variants)-len(formats)].Url
+	return nil
+}
+
+// recompressJPEG recompresses the JPEG upload into JPEG XL losslessly, its EXIF and other metadata is stripped first
+func (v *Task) recompressJPEG(dst string, enc *ffmpegx.ImageEncoding) error {
+	stripped := filepath.Join(AppDir, v.Id+".stripped.jpg")
+	e := exif.StripJPEG(stripped, v.Origin)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	defer os.Remove(stripped)
+	return ffmpegx.RecompressJPEG(dst, stripped, enc)
+}
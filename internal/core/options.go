@@ -0,0 +1,98 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+	"github.com/StevenZack/transcoder/internal/tools"
+)
+
+// Options are the per-submission settings of tasks
+type Options struct {
+	Profile *Profile
+	Clip    *ffmpegx.Clip
+	Crop    *ffmpegx.Rect         // overrides the crop rectangle of the profile
+	Cover   *ffmpegx.CoverOptions // overrides the cover options of the profile
+
+	Lossless bool // forces lossless image outputs, e.g. for screenshots
+	Page     int  // 1-based page of PDF to rasterize, 0 is the first
+
+	Durations []float64 // seconds of each image in a concat task, by upload index
+}
+
+const (
+	DEFAULT_IMAGE_DURATION = 3
+)
+
+// Layout returns the layout of the profile, with the crop override applied
+func (o *Options) Layout() *ffmpegx.Layout {
+	l := o.Profile.Layout
+	if o.Crop != nil {
+		l.Crop = o.Crop
+	}
+	return &l
+}
+
+// Key identifies the options outputs are made with, uploads of the same content and key have the same outputs
+func (o *Options) Key() string {
+	b, _ := json.Marshal(struct {
+		Profile  string
+		Clip     *ffmpegx.Clip
+		Crop     *ffmpegx.Rect
+		Cover    *ffmpegx.CoverOptions
+		Lossless bool
+		Page     int
+	}{o.Profile.Name, o.Clip, o.Crop, o.Cover, o.Lossless, o.Page})
+	return string(b)
+}
+
+// CoverOptions returns the cover options of the profile, or the override
+func (o *Options) CoverOptions() *ffmpegx.CoverOptions {
+	if o.Cover != nil {
+		return o.Cover
+	}
+	return &o.Profile.Cover
+}
+
+// ImageEncoding returns the encoder settings of an image format, with defaults filled
+func (o *Options) ImageEncoding(format string) ffmpegx.ImageEncoding {
+	enc := o.Profile.Images[format]
+	if o.Lossless {
+		enc.Lossless = true
+	}
+	return enc.WithDefaults(format)
+}
+
+// ImageDuration returns the seconds the i-th upload of a concat task is shown, if it's an image
+func (o *Options) ImageDuration(i int) float64 {
+	if i < len(o.Durations) && o.Durations[i] > 0 {
+		return o.Durations[i]
+	}
+	return DEFAULT_IMAGE_DURATION
+}
+
+// ParseDurations parses comma separated timestamps, empty items are left as 0
+func ParseDurations(s string) ([]float64, error) {
+	out := []float64{}
+	if s == "" {
+		return out, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			out = append(out, 0)
+			continue
+		}
+		d, e := tools.ParseTimestamp(item)
+		if e != nil {
+			return nil, e
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+var (
+	ErrInvalidOptions = errors.New("invalid options")
+)
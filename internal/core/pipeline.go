@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+)
+
+var errCanceled = errors.New("task is cleaned")
+
+// pipeline is the background work of a video task after CreateTask returns,
+// tasks are stored by value, so it's shared by every copy of the task through a pointer
+type pipeline struct {
+	ctx      context.Context // done once the task is cleaned, killing the commands of the steps
+	stop     context.CancelFunc
+	lock     sync.Mutex
+	ended    bool  // every step has returned
+	e        error // the step that failed, if any
+	canceled bool  // the task is cleaned, steps stop and what they still write is removed
+
+	pending []string        // files listed upfront that the steps write, removed if the task is cleaned meanwhile
+	outputs []string        // files written by the steps, listed like OutputFiles
+	results []func(t *Task) // what the steps made, set on every copy of the task
+}
+
+func newPipeline() *pipeline {
+	ctx, stop := context.WithCancel(context.Background())
+	return &pipeline{ctx: ctx, stop: stop}
+}
+
+// run runs steps in order after the encodes returned e, until one fails or the task is cleaned, nil steps are skipped
+func (p *pipeline) run(e error, steps ...func() error) {
+	for _, step := range steps {
+		if e != nil || p.isCanceled() {
+			break
+		}
+		if step != nil {
+			e = step()
+		}
+	}
+	if p.isCanceled() {
+		removeFiles(p.pending)
+	}
+	p.end(e)
+}
+
+// end records that the background work returned e
+func (p *pipeline) end(e error) {
+	if e != nil {
+		log.Println(e)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.ended, p.e = true, e
+	p.stop()
+}
+
+// commit lists files written by a step as outputs, result sets what the step made on the task.
+// It returns false if the task is cleaned meanwhile, the files are removed then
+func (p *pipeline) commit(files []string, result func(t *Task)) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.canceled {
+		removeFiles(files)
+		return false
+	}
+	for _, f := range files {
+		acquire(appPath(f))
+	}
+	p.outputs = append(p.outputs, files...)
+	if result != nil {
+		p.results = append(p.results, result)
+	}
+	return true
+}
+
+func (p *pipeline) cancel() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.canceled = true
+	p.stop()
+}
+
+func (p *pipeline) isCanceled() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.canceled
+}
+
+// load copies the state into a copy of the task
+func (p *pipeline) load(t *Task) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	t.IsEnded = p.ended
+	if p.e != nil {
+		t.Error = p.e.Error()
+	}
+	for _, output := range p.outputs {
+		if !contains(t.OutputFiles, output) {
+			t.OutputFiles = append(t.OutputFiles, output)
+		}
+	}
+	for _, result := range p.results {
+		result(t)
+	}
+}
+
+// removeFiles removes files in AppDir, for outputs left by a failed or canceled step
+func removeFiles(files []string) {
+	for _, f := range files {
+		e := os.Remove(appPath(f))
+		if e != nil && !os.IsNotExist(e) {
+			log.Println(e)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+	"github.com/StevenZack/transcoder/internal/palette"
+	"github.com/StevenZack/transcoder/internal/placeholder"
+)
+
+const (
+	PLACEHOLDER_SIZE      = 100 // ThumbHash takes at most 100x100
+	BLURHASH_X_COMPONENTS = 4
+	BLURHASH_Y_COMPONENTS = 3
+)
+
+// setPlaceholders computes the BlurHash, ThumbHash and palette of filename, laid out by vf into w×h
+func (t *Task) setPlaceholders(filename, vf string, w, h int) error {
+	if w <= 0 || h <= 0 {
+		e := fmt.Errorf("invalid size %dx%d of %s", w, h, filepath.Base(filename))
+		log.Println(e)
+		return e
+	}
+	pw, ph := PLACEHOLDER_SIZE, PLACEHOLDER_SIZE
+	if w > h {
+		ph = h * PLACEHOLDER_SIZE / w
+	} else {
+		pw = w * PLACEHOLDER_SIZE / h
+	}
+	if pw < 1 {
+		pw = 1
+	}
+	if ph < 1 {
+		ph = 1
+	}
+	rgba, e := ffmpegx.DecodeRGBA(filename, vf, pw, ph)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	t.ThumbHash, e = placeholder.ThumbHash(pw, ph, rgba)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	t.BlurHash = placeholder.BlurHash(BLURHASH_X_COMPONENTS, BLURHASH_Y_COMPONENTS, pw, ph, rgba)
+	size := DEFAULT_PALETTE_SIZE
+	if p, ok := Profiles[t.Profile]; ok && p.Palette > 0 {
+		size = p.Palette
+	}
+	t.Palette = palette.Extract(rgba, size)
+	return nil
+}
+
+// setCoverPlaceholders computes the placeholders and palette of a video from its cover
+func (t *Task) setCoverPlaceholders() error {
+	filename := filepath.Join(AppDir, filepath.Base(t.Cover))
+	info, e := ffmpegx.ProbeMedia(filename)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	return t.setPlaceholders(filename, "", info.Width, info.Height)
+}
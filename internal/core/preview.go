@@ -0,0 +1,42 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+// createPreview writes the looping MP4 and WebP previews of a video, vf lays the video out into w×h
+func (v *Task) createPreview(filename, vf string, w, h int, opt ffmpegx.PreviewOptions) error {
+	opt = opt.WithDefaults()
+	pw := w
+	if opt.Width < pw {
+		pw = opt.Width
+	}
+	pw = ffmpegx.Even(pw)
+	ph := ffmpegx.Even(pw * h / w)
+	offsets, length := opt.SnippetOffsets(v.MediaInfo.DurationSeconds)
+	for i := range offsets {
+		offsets[i] += v.Clip.StartAt()
+	}
+
+	mp4 := filename + ".preview.mp4"
+	e := ffmpegx.CreatePreviewMP4(filepath.Join(AppDir, mp4), v.Origin, offsets, length, fmt.Sprintf("%s,scale=%d:%d", vf, pw, ph))
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	v.OutputFiles = append(v.OutputFiles, mp4)
+
+	webp := filename + ".preview.webp"
+	e = ffmpegx.CreatePreviewWebP(filepath.Join(AppDir, webp), filepath.Join(AppDir, mp4))
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	v.OutputFiles = append(v.OutputFiles, webp)
+	v.Previews = []string{PUBLIC_PREFIX + mp4, PUBLIC_PREFIX + webp}
+	return nil
+}
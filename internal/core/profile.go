@@ -0,0 +1,195 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/StevenZack/transcoder/internal/exif"
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+	"github.com/StevenZack/transcoder/internal/raster"
+)
+
+// Profile is a named set of output settings, selected by the `profile` field of a submission
+type Profile struct {
+	Name   string         `json:"name"`
+	Layout ffmpegx.Layout `json:"layout"`
+	HDR    string         `json:"hdr"` // sdr|passthrough, how HDR sources are encoded
+
+	Deinterlace string `json:"deinterlace"` // yadif|bwdif, detects interlaced content and deinterlaces it, empty skips the analysis
+	Animation   string `json:"animation"`   // image|mp4, output of animated GIF/APNG
+	Widths      []int  `json:"widths"`      // width ladder of images, e.g. 320/640/1080/2048, the original size is always included
+
+	Formats []string                         `json:"formats"` // avif|webp|jxl outputs of images, avif and webp by default
+	Images  map[string]ffmpegx.ImageEncoding `json:"images"`  // encoder settings by image format, unset fields use the defaults
+
+	KeepMetadata []string `json:"keepMetadata"` // copyright|icc kept in image outputs, everything else is stripped
+
+	Sprite   *ffmpegx.SpriteOptions  `json:"sprite"` // thumbnail sprite sheets of videos, nil disables them
+	Cover    ffmpegx.CoverOptions    `json:"cover"`
+	Preview  *ffmpegx.PreviewOptions `json:"preview"`  // looping preview clips of videos, nil disables them
+	Chapters *ffmpegx.ChapterOptions `json:"chapters"` // chapters of videos at scene changes, nil disables them
+	Blank    *ffmpegx.BlankOptions   `json:"blank"`    // black frame and silence analysis of videos, nil skips it
+	Quality  bool                    `json:"quality"`  // measures VMAF, or SSIM and PSNR, of video outputs after encoding
+
+	TargetVMAF float64 `json:"targetVmaf"` // searches the CRF of video outputs reaching it instead of the fixed ones, 0 disables, needs libvmaf
+
+	Palette int `json:"palette"` // number of dominant colors of images and video covers, 5 by default
+
+	Document raster.Options `json:"document"` // rasterizing of PDF and SVG
+}
+
+const (
+	DEFAULT_PROFILE = "default"
+
+	ANIMATION_IMAGE = "image" // animated AVIF and WebP
+	ANIMATION_MP4   = "mp4"   // muted MP4
+
+	DEFAULT_PALETTE_SIZE = 5
+	MAX_PALETTE_SIZE     = 16
+)
+
+var (
+	Profiles = map[string]*Profile{
+		DEFAULT_PROFILE: {
+			Layout: ffmpegx.Layout{Mode: ffmpegx.LAYOUT_FIT},
+			Cover:  ffmpegx.CoverOptions{Mode: ffmpegx.COVER_AUTO},
+			Widths: []int{320, 640, 1080, 2048},
+		},
+		"square": {
+			Layout: ffmpegx.Layout{Mode: ffmpegx.LAYOUT_FILL, Aspect: "1:1"},
+			Cover:  ffmpegx.CoverOptions{Mode: ffmpegx.COVER_AUTO},
+		},
+		"portrait": {
+			Layout: ffmpegx.Layout{Mode: ffmpegx.LAYOUT_FILL, Aspect: "4:5"},
+			Cover:  ffmpegx.CoverOptions{Mode: ffmpegx.COVER_AUTO},
+		},
+		"story": {
+			Layout: ffmpegx.Layout{Mode: ffmpegx.LAYOUT_PAD, Aspect: "9:16", PadColor: ffmpegx.PAD_BLUR},
+			Cover:  ffmpegx.CoverOptions{Mode: ffmpegx.COVER_AUTO},
+		},
+	}
+)
+
+func init() {
+	for name, p := range Profiles {
+		p.Name = name
+	}
+}
+
+// LoadProfiles reads a JSON object of name => profile, adding to or overriding the built-in profiles
+func LoadProfiles(filename string) error {
+	b, e := os.ReadFile(filename)
+	if e != nil {
+		return e
+	}
+	m := map[string]*Profile{}
+	e = json.Unmarshal(b, &m)
+	if e != nil {
+		return fmt.Errorf("parse %s failed:%w", filename, e)
+	}
+	for name, p := range m {
+		e = p.Validate()
+		if e != nil {
+			return fmt.Errorf("profile %s:%w", name, e)
+		}
+		p.Name = name
+		Profiles[name] = p
+	}
+	return nil
+}
+
+func (p *Profile) Validate() error {
+	e := p.Layout.Validate()
+	if e != nil {
+		return e
+	}
+	e = p.Cover.Validate()
+	if e != nil {
+		return e
+	}
+	for _, format := range p.Formats {
+		e = ffmpegx.ValidateImageFormat(format)
+		if e != nil {
+			return e
+		}
+	}
+	for _, keep := range p.KeepMetadata {
+		e = exif.ValidateKeep(keep)
+		if e != nil {
+			return e
+		}
+	}
+	for format, enc := range p.Images {
+		e = enc.Validate(format)
+		if e != nil {
+			return e
+		}
+	}
+	e = p.Document.Validate()
+	if e != nil {
+		return e
+	}
+	if p.Sprite != nil {
+		e = p.Sprite.Validate()
+		if e != nil {
+			return e
+		}
+	}
+	if p.Preview != nil {
+		e = p.Preview.Validate()
+		if e != nil {
+			return e
+		}
+	}
+	if p.Chapters != nil {
+		e = p.Chapters.Validate()
+		if e != nil {
+			return e
+		}
+	}
+	if p.Blank != nil {
+		e = p.Blank.Validate()
+		if e != nil {
+			return e
+		}
+	}
+	if p.TargetVMAF < 0 || p.TargetVMAF > 100 {
+		return errors.New("targetVmaf must be within 0-100")
+	}
+	if p.TargetVMAF > 0 && !ffmpegx.HasFilter("libvmaf") {
+		return errors.New("targetVmaf needs ffmpeg with libvmaf")
+	}
+	if p.Palette < 0 || p.Palette > MAX_PALETTE_SIZE {
+		return fmt.Errorf("palette must be within 0-%d", MAX_PALETTE_SIZE)
+	}
+	switch p.HDR {
+	case "", ffmpegx.HDR_SDR, ffmpegx.HDR_PASSTHROUGH:
+	default:
+		return errors.New("unsupported hdr mode:" + p.HDR)
+	}
+	switch p.Deinterlace {
+	case "", ffmpegx.DEINTERLACE_YADIF, ffmpegx.DEINTERLACE_BWDIF:
+	default:
+		return errors.New("unsupported deinterlacer:" + p.Deinterlace)
+	}
+	switch p.Animation {
+	case "", ANIMATION_IMAGE, ANIMATION_MP4:
+	default:
+		return errors.New("unsupported animation output:" + p.Animation)
+	}
+	return nil
+}
+
+// GetProfile returns the profile of name, or the default one if name is empty
+func GetProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = DEFAULT_PROFILE
+	}
+	p, ok := Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown profile %s", ErrInvalidOptions, name)
+	}
+	return p, nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+// qualityTarget is a video output to measure, vf is the layout it was encoded with
+type qualityTarget struct {
+	url      string
+	filename string
+	vf       string
+}
+
+// measureQuality returns the step measuring targets against the source read with input, it writes the reports by url into dst.
+// Targets failing to be measured are only left out
+func measureQuality(dst string, input []string, targets []qualityTarget) func() error {
+	return func() error {
+		reports := map[string]*ffmpegx.QualityReport{}
+		for _, target := range targets {
+			report, e := ffmpegx.MeasureQuality(target.filename, input, target.vf)
+			if e != nil {
+				log.Println(e)
+				continue
+			}
+			log.Println(target.url, report)
+			reports[target.url] = report
+		}
+		b, e := json.Marshal(reports)
+		if e != nil {
+			log.Println(e)
+			return e
+		}
+		// written whole, since tasks may read it at any time
+		e = os.WriteFile(dst+".tmp", b, 0644)
+		if e != nil {
+			log.Println(e)
+			return e
+		}
+		e = os.Rename(dst+".tmp", dst)
+		if e != nil {
+			log.Println(e)
+			return e
+		}
+		return nil
+	}
+}
+
+// loadQuality reads the quality reports, once they're written
+func (t *Task) loadQuality() {
+	if t.QualityFile == "" || t.Quality != nil {
+		return
+	}
+	b, e := os.ReadFile(t.QualityFile)
+	if e != nil {
+		return
+	}
+	e = json.Unmarshal(b, &t.Quality)
+	if e != nil {
+		log.Println(e)
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"log"
+	"sort"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+	"github.com/StevenZack/transcoder/internal/phash"
+)
+
+const (
+	FINGERPRINT_FRAMES       = 16 // frames sampled evenly across a video
+	DEFAULT_SIMILAR_DISTANCE = 10
+	MAX_SIMILAR_DISTANCE     = 32
+)
+
+// SimilarTask is a task perceptually close to another, Distance is in differing bits of 64
+type SimilarTask struct {
+	Task
+	Distance int `json:"distance"`
+}
+
+// setImageHashes computes the pHash and dHash of the image, vf orients it
+func (t *Task) setImageHashes(vf string) error {
+	gray, e := ffmpegx.DecodeGray(t.Origin, vf, phash.PHASH_SIZE, phash.PHASH_SIZE)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	t.PHash = phash.PHash(gray)
+	gray, e = ffmpegx.DecodeGray(t.Origin, vf, phash.DHASH_WIDTH, phash.DHASH_HEIGHT)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	t.DHash = phash.DHash(gray)
+	return nil
+}
+
+// setFingerprint computes the pHash of frames sampled evenly across the video, within the clip if any
+func (t *Task) setFingerprint() error {
+	if t.MediaInfo.DurationSeconds <= 0 {
+		return nil
+	}
+	offsets := []float64{}
+	for i := 0; i < FINGERPRINT_FRAMES; i++ {
+		offsets = append(offsets, t.Clip.StartAt()+(float64(i)+0.5)*float64(t.MediaInfo.DurationSeconds)/FINGERPRINT_FRAMES)
+	}
+	gray, e := ffmpegx.DecodeGrayFrames(t.Origin, offsets, phash.PHASH_SIZE, phash.PHASH_SIZE)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	size := phash.PHASH_SIZE * phash.PHASH_SIZE
+	t.Fingerprint = nil
+	for i := 0; i+size <= len(gray); i += size {
+		t.Fingerprint = append(t.Fingerprint, phash.PHash(gray[i:i+size]))
+	}
+	return nil
+}
+
+// distance compares images by the larger of their pHash and dHash distances, and videos by their fingerprints
+func (t *Task) distance(o *Task) (int, bool) {
+	if len(t.Fingerprint) > 0 || len(o.Fingerprint) > 0 {
+		return phash.SequenceDistance(t.Fingerprint, o.Fingerprint)
+	}
+	if t.PHash == 0 && t.DHash == 0 || o.PHash == 0 && o.DHash == 0 {
+		return 0, false
+	}
+	d := phash.Distance(t.PHash, o.PHash)
+	if dd := phash.Distance(t.DHash, o.DHash); dd > d {
+		d = dd
+	}
+	return d, true
+}
+
+// FindSimilar returns the other tasks of the same user within maxDistance of t, closest first
+func FindSimilar(t *Task, maxDistance int) []SimilarTask {
+	out := []SimilarTask{}
+	TaskMap.Range(func(key string, value Task) bool {
+		if value.Id == t.Id || value.User != t.User {
+			return true
+		}
+		if d, ok := t.distance(&value); ok && d <= maxDistance {
+			out = append(out, SimilarTask{Task: value, Distance: d})
+		}
+		return true
+	})
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Distance < out[j].Distance
+	})
+	return out
+}
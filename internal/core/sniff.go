@@ -0,0 +1,92 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+	"github.com/StevenZack/transcoder/internal/heif"
+	"github.com/StevenZack/transcoder/internal/sniff"
+)
+
+const (
+	HEIF_CONTAINER = "heif"
+)
+
+var (
+	ErrUnsupportedMedia = errors.New("unsupported media")
+)
+
+// detectUpload recognizes a saved upload by its magic bytes, confirmed by the demuxer ffprobe picks,
+// and renames it to the detected extension, since ffmpeg and friends go by extensions too.
+// name is the uploaded file name, for errors
+func detectUpload(filename, name string) (string, *sniff.Type, string, error) {
+	t, e := sniff.DetectFile(filename)
+	if e != nil {
+		log.Println(e)
+		return "", nil, "", e
+	}
+	if t == nil {
+		return "", nil, "", fmt.Errorf("%w: %s isn't a recognized image or video", ErrUnsupportedMedia, name)
+	}
+	container := ""
+	switch {
+	case isHEIF(t.Mime):
+		// older ffprobe can't read HEIF without a sequence track, its items are checked by our own parser
+		_, e = heif.ReadFile(filename)
+		if e != nil {
+			log.Println(e)
+			return "", nil, "", fmt.Errorf("%w: %s looks like %s, but its structure is broken", ErrUnsupportedMedia, name, t.Mime)
+		}
+		container = HEIF_CONTAINER
+	case t.Demuxers == nil:
+		// documents are checked when they're rasterized
+		container = strings.TrimPrefix(t.Ext, ".")
+	default:
+		container, e = ffmpegx.ProbeFormat(filename)
+		if e != nil {
+			log.Println(e)
+			return "", nil, "", fmt.Errorf("%w: %s looks like %s, but ffprobe can't read it", ErrUnsupportedMedia, name, t.Mime)
+		}
+		if !t.Matches(container) {
+			return "", nil, "", fmt.Errorf("%w: %s looks like %s, but ffprobe reads it as %s", ErrUnsupportedMedia, name, t.Mime, container)
+		}
+		// audio only containers are read by the same demuxers
+		info, e := ffmpegx.ProbeMedia(filename)
+		if e != nil {
+			log.Println(e)
+			return "", nil, "", fmt.Errorf("%w: %s looks like %s, but ffprobe can't read it", ErrUnsupportedMedia, name, t.Mime)
+		}
+		if info.CodedWidth == 0 || info.CodedHeight == 0 {
+			return "", nil, "", fmt.Errorf("%w: %s has no video stream", ErrUnsupportedMedia, name)
+		}
+	}
+
+	if !strings.EqualFold(filepath.Ext(filename), t.Ext) {
+		renamed := strings.TrimSuffix(filename, filepath.Ext(filename)) + t.Ext
+		e = os.Rename(filename, renamed)
+		if e != nil {
+			log.Println(e)
+			return "", nil, "", e
+		}
+		filename = renamed
+	}
+	return filename, t, container, nil
+}
+
+// upload returns the uploaded file of a single upload task, which differs from Origin for decoded HEIF and rasterized documents
+func (t *Task) upload() string {
+	if (isHEIF(t.Mime) || isDocument(t.Mime)) && len(t.Inputs) > 0 {
+		return t.Inputs[0]
+	}
+	return t.Origin
+}
+
+// unsupportedHDR is the error of HDR uploads this ffmpeg can't tone-map
+func unsupportedHDR(name string) error {
+	return fmt.Errorf("%w: %s is HDR, but ffmpeg isn't built with zscale (libzimg) to tone-map it", ErrUnsupportedMedia, name)
+}
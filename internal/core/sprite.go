@@ -0,0 +1,56 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
+)
+
+// spriteStep returns the background step creating the sprite sheets and the WebVTT thumbnail track of a video,
+// they're listed as outputs once all are written. vf lays the video out into w×h, the thumbnails keep that aspect.
+func (v *Task) spriteStep(filename, vf string, w, h int, opt ffmpegx.SpriteOptions) func() error {
+	duration := v.MediaInfo.DurationSeconds
+	if duration == 0 {
+		return nil
+	}
+	opt = opt.WithDefaults()
+	tw := ffmpegx.Even(opt.Width)
+	th := ffmpegx.Even(tw * h / w)
+	_, count := opt.Count(duration)
+	perSheet := float64(opt.Columns*opt.Rows) * opt.Interval
+
+	p, origin, start := v.pipeline, v.Origin, v.Clip.StartAt()
+	return func() error {
+		sheets := []string{}
+		for i := 0; i < count; i++ {
+			if p.isCanceled() {
+				removeFiles(sheets)
+				return nil
+			}
+			sheet := fmt.Sprintf("%s.sprite.%d.%s", filename, i, opt.Format)
+			offset := float64(i) * perSheet
+			length := math.Min(perSheet, float64(duration)-offset)
+			e := opt.CreateSpriteSheet(filepath.Join(AppDir, sheet), origin, start+offset, length, vf, tw, th)
+			if e != nil {
+				log.Println(e)
+				removeFiles(append(sheets, sheet))
+				return fmt.Errorf("create sprites failed:%w", e)
+			}
+			sheets = append(sheets, sheet)
+		}
+		vtt := filename + ".sprites.vtt"
+		e := opt.WriteSpriteVTT(filepath.Join(AppDir, vtt), sheets, duration, tw, th)
+		if e != nil {
+			log.Println(e)
+			removeFiles(append(sheets, vtt))
+			return fmt.Errorf("create sprites failed:%w", e)
+		}
+		p.commit(append(sheets, vtt), func(t *Task) {
+			t.Thumbnails = PUBLIC_PREFIX + vtt
+		})
+		return nil
+	}
+}
@@ -4,16 +4,18 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"mime"
 	"mime/multipart"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/StevenZack/tools/strToolkit"
+	"github.com/StevenZack/transcoder/internal/exif"
 	"github.com/StevenZack/transcoder/internal/ffmpegx"
+	"github.com/StevenZack/transcoder/internal/palette"
+	"github.com/StevenZack/transcoder/internal/phash"
+	"github.com/StevenZack/transcoder/internal/sniff"
 	"github.com/StevenZack/transcoder/internal/tools"
 )
 
@@ -23,18 +25,50 @@ type (
 		User   string `json:"-"`
 		Origin string `json:"origin"`
 		Ext    string `json:"ext"`
-		Mime   string `json:"mime"`
+		Mime   string `json:"mime"` // detected from the content
+
+		Container string `json:"container"` // demuxers ffprobe reads the upload with, e.g. mov,mp4,m4a,3gp,3g2,mj2
+
+		Hash        string `json:"hash"`                  // SHA-256 of the upload
+		OptionsKey  string `json:"-"`                     // options the outputs were made with, see Options.Key
+		DuplicateOf string `json:"duplicateOf,omitempty"` // task whose outputs are reused, for identical uploads
+
+		Profile string        `json:"profile"`
+		Clip    *ffmpegx.Clip `json:"clip,omitempty"`
+		Inputs  []string      `json:"-"`               // uploads besides Origin, of a concat task, or the HEIF or document a PNG Origin is made from
+		Page    int           `json:"page,omitempty"`  // rasterized page of a PDF, 1-based
+		Pages   int           `json:"pages,omitempty"` // page count of a PDF
 
 		MediaInfo    *ffmpegx.MediaInfo    `json:"mediaInfo"`
 		ProgressInfo *ffmpegx.ProgressInfo `json:"progressInfo"`
-		Cmd          **exec.Cmd            `json:"-"`
 		ProgressFile string                `json:"-"`
 		IsEnded      bool                  `json:"isEnded"`
+		Error        string                `json:"error,omitempty"` // why the background work of a video failed
+		pipeline     *pipeline
+		segments     []coverSegment // inputs of a concat task, for its covers
 
-		PublicUrl    string   `json:"publicUrl"`   //
-		OutputFiles  []string `json:"outputFiles"` // output urls
-		CreateAt     string   `json:"createAt"`
-		CreateAtUnix int64    `json:"createAtUnix"`
+		PublicUrl     string                            `json:"publicUrl"`               //
+		Thumbnails    string                            `json:"thumbnails,omitempty"`    // WebVTT track of seek-bar previews
+		Cover         string                            `json:"cover,omitempty"`         // cover of videos
+		CoverEncoding *ffmpegx.ImageEncoding            `json:"coverEncoding,omitempty"` // settings the cover and chapter thumbnails are made with
+		Previews      []string                          `json:"previews,omitempty"`      // looping MP4 and WebP previews of videos
+		Variants      []Variant                         `json:"variants,omitempty"`      // sizes and formats of images
+		Metadata      *exif.Metadata                    `json:"metadata,omitempty"`      // EXIF of photos, only shown to the owner
+		BlurHash      string                            `json:"blurHash,omitempty"`      // placeholders of images and video covers
+		ThumbHash     string                            `json:"thumbHash,omitempty"`     // base64
+		Palette       []palette.Color                   `json:"palette,omitempty"`       // dominant colors of images and video covers
+		PHash         phash.Hash                        `json:"phash,omitempty"`         // perceptual hashes of images
+		DHash         phash.Hash                        `json:"dhash,omitempty"`         //
+		Fingerprint   []phash.Hash                      `json:"fingerprint,omitempty"`   // pHash of frames sampled across videos
+		CoverFilter   string                            `json:"-"`                       // lays out regenerated covers, empty if the cover can't be regenerated
+		Quality       map[string]*ffmpegx.QualityReport `json:"quality,omitempty"`       // quality of video outputs by url, when the profile measures it
+		Chapters      []ffmpegx.Chapter                 `json:"chapters,omitempty"`      // scenes of videos, when the profile detects them
+		ChaptersTrack string                            `json:"chaptersTrack,omitempty"` // WebVTT chapters of videos
+		CRFSearch     []*ffmpegx.CRFSearch              `json:"crfSearch,omitempty"`     // how the CRF of each video output was chosen, when the profile targets a VMAF
+		QualityFile   string                            `json:"-"`
+		OutputFiles   []string                          `json:"outputFiles"` // output urls
+		CreateAt      string                            `json:"createAt"`
+		CreateAtUnix  int64                             `json:"createAtUnix"`
 	}
 )
 
@@ -56,51 +90,129 @@ func init() {
 
 }
 
-func CreateTask(fh *multipart.FileHeader, user string) (*Task, error) {
+func CreateTask(fh *multipart.FileHeader, user string, opt *Options) (*Task, error) {
 	v := &Task{
 		Id:       tools.GenerateID(),
 		Origin:   fh.Filename,
 		Ext:      filepath.Ext(fh.Filename),
 		User:     user,
+		Profile:  opt.Profile.Name,
+		Clip:     opt.Clip,
 		CreateAt: time.Now().Format(time.RFC3339),
 	}
-	v.Mime = mime.TypeByExtension(v.Ext)
 
 	v.Origin = filepath.Join(AppDir, v.Id+v.Ext)
-	e := tools.ReadFileHeader(v.Origin, fh)
+	var e error
+	v.Hash, e = tools.ReadFileHeader(v.Origin, fh)
 	if e != nil {
 		log.Println(e)
 		return nil, e
 	}
+	v.OptionsKey = opt.Key()
+	if dup := findDuplicate(v.Hash, v.OptionsKey, v.User); dup != nil {
+		os.Remove(v.Origin)
+		return dup.reuse(v), nil
+	}
+	// the pipeline goes by content, extensions may be missing or lie
+	origin, t, container, e := detectUpload(v.Origin, fh.Filename)
+	if e != nil {
+		os.Remove(v.Origin)
+		return nil, e
+	}
+	v.Origin, v.Mime, v.Container = origin, t.Mime, container
+
+	if opt.Page > 0 && v.Mime != sniff.PDF.Mime {
+		os.Remove(v.Origin)
+		return nil, fmt.Errorf("%w: page only applies to PDF", ErrInvalidOptions)
+	}
+	if v.Clip != nil && !strings.HasPrefix(v.Mime, "video/") {
+		os.Remove(v.Origin)
+		return nil, fmt.Errorf("%w: clips only apply to videos", ErrInvalidOptions)
+	}
 
 	switch strToolkit.SubBefore(v.Mime, "/", v.Mime) {
-	case "image":
+	case "image", "application": // PDF goes through the image pipeline
+		// EXIF orientation is applied by ourselves, ffmpeg doesn't handle it the same way across versions
+		var orientation *exif.Metadata
+		if isHEIF(v.Mime) {
+			// HEIF is decoded into a PNG the rest reads instead, the upload is kept as an input
+			decoded, metadata, oriented, e := decodeHEIF(v.Origin)
+			if e != nil {
+				os.Remove(v.Origin)
+				return nil, e
+			}
+			v.Inputs = append(v.Inputs, v.Origin)
+			v.Origin, v.Metadata = decoded, metadata
+			if !oriented {
+				orientation = metadata
+			}
+		} else if isDocument(v.Mime) {
+			// the same for the rasterized page of documents
+			rasterized, pages, e := rasterize(v.Origin, v.Mime, opt.Page, opt.Profile.Document)
+			if e != nil {
+				os.Remove(v.Origin)
+				return nil, e
+			}
+			v.Inputs = append(v.Inputs, v.Origin)
+			v.Origin, v.Pages = rasterized, pages
+			if v.Mime == sniff.PDF.Mime {
+				v.Page = opt.Page
+				if v.Page == 0 {
+					v.Page = 1
+				}
+			}
+		} else {
+			v.Metadata, e = exif.ReadJPEG(v.Origin)
+			if e != nil {
+				// broken EXIF doesn't matter, since it's stripped anyway
+				log.Println(e)
+			}
+			orientation = v.Metadata
+		}
 		// media_info
 		v.MediaInfo, e = ffmpegx.ProbeMedia(v.Origin)
 		if e != nil {
 			log.Println(e)
 			return nil, e
 		}
-		filename := fmt.Sprintf("%s@%dx%d", v.Id, v.MediaInfo.Width, v.MediaInfo.Height)
-		// avif
-		avif := filepath.Join(AppDir, filename+".avif")
-		e = ffmpegx.CompressImage(avif, v.Origin)
+		v.MediaInfo.Frames, v.MediaInfo.LoopCount, e = ffmpegx.ProbeAnimation(v.Origin)
 		if e != nil {
 			log.Println(e)
 			return nil, e
 		}
-		v.OutputFiles = append(v.OutputFiles, filename+".avif")
-
-		// webp
-		webp := filepath.Join(AppDir, filename+".webp")
-		e = ffmpegx.CompressImage(webp, v.Origin)
+		if orientation != nil && orientation.Orientation > 1 {
+			v.MediaInfo.Rotation = 0
+			v.MediaInfo.Width, v.MediaInfo.Height = v.MediaInfo.CodedWidth, v.MediaInfo.CodedHeight
+			if orientation.SwapsSize() {
+				v.MediaInfo.Width, v.MediaInfo.Height = v.MediaInfo.CodedHeight, v.MediaInfo.CodedWidth
+			}
+		}
+		// images are read with -noautorotate, so the displaymatrix the size is swapped for is applied by ourselves too
+		transpose := orientation.TransposeFilter()
+		if transpose == "" {
+			transpose = v.MediaInfo.RotationFilter()
+		}
+		vf, w, h := opt.Layout().Filter(v.MediaInfo.Width, v.MediaInfo.Height, v.MediaInfo.Width, v.MediaInfo.Height)
+		if transpose != "" {
+			vf = transpose + "," + vf
+		}
+		// placeholders are optional, a failure only leaves them empty
+		v.setPlaceholders(v.Origin, vf, w, h)
+		// hashed before the layout, so crops and pads of the same image stay close
+		v.setImageHashes(transpose)
+		filename := fmt.Sprintf("%s@%dx%d", v.Id, w, h)
+		if v.MediaInfo.IsAnimated() {
+			e = createAnimatedOutputs(v, filename, vf, w, h, opt)
+			if e != nil {
+				return nil, e
+			}
+			v.IsEnded = true
+			break
+		}
+		e = v.createImageVariants(vf, w, h, opt)
 		if e != nil {
-			log.Println(e)
 			return nil, e
 		}
-		v.OutputFiles = append(v.OutputFiles, filename+".webp")
-
-		v.PublicUrl = PUBLIC_PREFIX + filename + ".avif"
 
 		// delete v.Origin
 		// e = os.Remove(v.Origin)
@@ -111,32 +223,134 @@ func CreateTask(fh *multipart.FileHeader, user string) (*Task, error) {
 		v.IsEnded = true
 	case "video":
 		// media_info
-		v.MediaInfo, e = ffmpegx.ProbeVideoAuto(v.Origin)
+		v.MediaInfo, e = ffmpegx.ProbeMedia(v.Origin)
 		if e != nil {
 			log.Println(e)
 			return nil, e
 		}
-		w, h := ffmpegx.FitConstraint(ffmpegx.MAX_AV1_CONSTRAINT, ffmpegx.MAX_AV1_CONSTRAINT, v.MediaInfo.Width, v.MediaInfo.Height)
+		if v.Clip != nil {
+			if v.MediaInfo.Duration > 0 && v.Clip.Start >= v.MediaInfo.Duration {
+				os.Remove(v.Origin)
+				return nil, fmt.Errorf("%w: start exceeds the duration of %s", ErrInvalidOptions, fh.Filename)
+			}
+			// progress is reported against the clipped length
+			v.MediaInfo.DurationSeconds = v.Clip.Length(v.MediaInfo.Duration)
+		}
+		if opt.Profile.Blank != nil {
+			e = v.analyzeBlank(*opt.Profile.Blank)
+			if e != nil {
+				return nil, e
+			}
+		}
+		layout := opt.Layout()
+		vf, w, h := layout.Filter(ffmpegx.MAX_AV1_CONSTRAINT, ffmpegx.MAX_AV1_CONSTRAINT, v.MediaInfo.Width, v.MediaInfo.Height)
+		vfHEVC, _, _ := layout.Filter(ffmpegx.MAX_HEVC_CONSTRAINT, ffmpegx.MAX_HEVC_CONSTRAINT, v.MediaInfo.Width, v.MediaInfo.Height)
+		if opt.Profile.Deinterlace != "" {
+			v.MediaInfo.FieldOrder, e = ffmpegx.DetectFieldOrder(v.Origin, v.Clip.StartAt())
+			if e != nil {
+				log.Println(e)
+				return nil, e
+			}
+			if v.MediaInfo.IsInterlaced() {
+				deint := ffmpegx.DeinterlaceFilter(opt.Profile.Deinterlace, v.MediaInfo.FieldOrder)
+				vf = deint + "," + vf
+				vfHEVC = deint + "," + vfHEVC
+			}
+		}
+		vfCover := vf
+		var colorArgs []string
+		if v.MediaInfo.IsHDR() {
+			if !ffmpegx.CanTonemap() {
+				os.Remove(v.Origin)
+				return nil, unsupportedHDR(fh.Filename)
+			}
+			// covers are always SDR
+			vfCover += "," + ffmpegx.TONEMAP_FILTER
+			if opt.Profile.HDR == ffmpegx.HDR_PASSTHROUGH {
+				colorArgs = v.MediaInfo.HDRColorArgs()
+			} else {
+				vf += "," + ffmpegx.TONEMAP_FILTER
+				vfHEVC += "," + ffmpegx.TONEMAP_FILTER
+				colorArgs = ffmpegx.SDR_COLOR_ARGS
+			}
+		}
 		filename := fmt.Sprintf("%s@%dx%d", v.Id, w, h)
 		v.ProgressFile = filepath.Join(AppDir, filename+".progress.txt")
+		v.pipeline = newPipeline()
 		// cover
-		coverAvif := filepath.Join(AppDir, filename+".cover.avif")
-		e = ffmpegx.CreateCoverOfVideo(coverAvif, v.Origin, w, h)
+		e = v.createCover(filename+".cover.avif", vfCover, opt.CoverOptions(), opt.ImageEncoding("avif"))
 		if e != nil {
 			log.Println(e)
 			return nil, e
 		}
 		v.OutputFiles = append(v.OutputFiles, filename+".cover.avif")
+		v.setCoverPlaceholders()
+		v.setFingerprint()
+
+		// preview
+		if opt.Profile.Preview != nil {
+			e = v.createPreview(filename, vfCover, w, h, *opt.Profile.Preview)
+			if e != nil {
+				return nil, e
+			}
+		}
+
+		// sprites, made after the encodes
+		var sprites func() error
+		if opt.Profile.Sprite != nil {
+			sprites = v.spriteStep(filename, vfCover, w, h, *opt.Profile.Sprite)
+		}
+
+		// chapters, detected before the encodes which write them
+		var chapters func() error
+		chapterMetadata := ""
+		if opt.Profile.Chapters != nil {
+			chapterMetadata, chapters = v.chapterStep(filename, vfCover, *opt.Profile.Chapters)
+		}
 
 		// video
-		wHEVC, hHEVC := ffmpegx.FitConstraint(ffmpegx.MAX_HEVC_CONSTRAINT, ffmpegx.MAX_HEVC_CONSTRAINT, v.MediaInfo.Width, v.MediaInfo.Height)
 		av1 := filepath.Join(AppDir, filename+".av1.mp4")
 		hevc := filepath.Join(AppDir, filename+".hevc.mp4")
-		v.Cmd, e = ffmpegx.CompressToAV1_HEVC(av1, hevc, v.Origin, v.ProgressFile, w, h, wHEVC, hHEVC)
-		if e != nil {
-			log.Println(e)
-			return nil, e
+		input := append(v.Clip.InputArgs(), "-i", v.Origin)
+		var measure func() error
+		if opt.Profile.Quality {
+			v.QualityFile = filepath.Join(AppDir, filename+".quality.json")
+			measure = measureQuality(v.QualityFile, input, []qualityTarget{
+				{PUBLIC_PREFIX + filename + ".av1.mp4", av1, vf},
+				{PUBLIC_PREFIX + filename + ".hevc.mp4", hevc, vfHEVC},
+			})
+		}
+		pipe := v.pipeline
+		pipe.pending = []string{v.ProgressFile, av1, hevc}
+		if v.QualityFile != "" {
+			pipe.pending = append(pipe.pending, v.QualityFile)
+		}
+		done := func(e error) {
+			pipe.run(e, sprites, measure)
+		}
+		var searchCRF func() (ffmpegx.VideoCRF, error)
+		if opt.Profile.TargetVMAF > 0 {
+			searchCRF = v.crfStep(filename, vf, vfHEVC, colorArgs, opt.Profile.TargetVMAF)
+		}
+		prepare := func() (ffmpegx.VideoCRF, error) {
+			if chapters != nil {
+				e := chapters()
+				if e != nil {
+					return ffmpegx.DEFAULT_VIDEO_CRF, e
+				}
+			}
+			if searchCRF != nil {
+				return searchCRF()
+			}
+			return ffmpegx.DEFAULT_VIDEO_CRF, nil
 		}
+		encodeInput, outputArgs := input, colorArgs
+		if chapterMetadata != "" {
+			// replacing the chapters of the source
+			encodeInput = append(append([]string{}, input...), "-i", chapterMetadata)
+			outputArgs = append(append([]string{}, colorArgs...), "-map_chapters", "1")
+		}
+		ffmpegx.CompressToAV1_HEVC(v.pipeline.ctx, av1, hevc, v.ProgressFile, encodeInput, ffmpegx.VideoFilterArgs(vf), ffmpegx.VideoFilterArgs(vfHEVC), outputArgs, prepare, done)
 
 		v.OutputFiles = append(v.OutputFiles, av1, hevc)
 		v.PublicUrl = PUBLIC_PREFIX + filename + ".av1.mp4"
@@ -145,6 +359,7 @@ func CreateTask(fh *multipart.FileHeader, user string) (*Task, error) {
 		return nil, errors.New("Unsupported file type :" + v.Mime)
 	}
 
+	acquire(v.files()...)
 	return v, nil
 }
 func (t *Task) currentProgress() (*ffmpegx.ProgressInfo, error) {
@@ -153,39 +368,43 @@ func (t *Task) currentProgress() (*ffmpegx.ProgressInfo, error) {
 			Progress: ffmpegx.PROGRESS_END,
 		}, nil
 	}
+	// the encodes may not have started yet
+	if _, e := os.Stat(t.ProgressFile); os.IsNotExist(e) {
+		return &ffmpegx.ProgressInfo{}, nil
+	}
 	return ffmpegx.TailProgressFile(t.ProgressFile)
 }
 
 func (t *Task) LoadProgress() error {
+	// quality is measured after the encodes end
+	t.loadQuality()
 	if t.IsEnded {
 		return nil
 	}
+	if t.pipeline != nil {
+		// ended once every background step returns, the progress file only tells about the encode running
+		t.pipeline.load(t)
+	}
 	if strings.HasPrefix(t.Mime, "video/") {
 		var e error
 		t.ProgressInfo, e = t.currentProgress()
 		if e != nil {
-			t.IsEnded = true
 			log.Println(e)
 			return e
 		}
-		t.IsEnded = t.ProgressInfo.Progress == ffmpegx.PROGRESS_END
 	}
 	return nil
 }
 
 func (t *Task) Clean() {
-	if t.Cmd != nil {
-		cmd := *t.Cmd
-		if cmd != nil {
-			cmd.Process.Kill()
-		}
-	}
-	os.Remove(t.Origin)
-	if t.ProgressFile != "" {
-		os.Remove(t.ProgressFile)
+	if t.pipeline != nil {
+		// background steps and their commands stop, what they've written is released below and what they still write is removed
+		t.pipeline.cancel()
+		t.pipeline.load(t)
 	}
-	for _, output := range t.OutputFiles {
-		e := os.Remove(output)
+	// files are shared by tasks of identical uploads
+	for _, f := range release(t.files()...) {
+		e := os.Remove(f)
 		if e != nil {
 			log.Println(e)
 		}
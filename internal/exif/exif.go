@@ -0,0 +1,292 @@
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+type (
+	// Metadata is what's extracted from the EXIF of a photo, it's never written into outputs unless kept explicitly
+	Metadata struct {
+		Orientation int    `json:"orientation,omitempty"` // 1-8
+		Make        string `json:"make,omitempty"`
+		Model       string `json:"model,omitempty"`
+		LensModel   string `json:"lensModel,omitempty"`
+		Software    string `json:"software,omitempty"`
+		DateTime    string `json:"dateTime,omitempty"` // original date time if any
+		Artist      string `json:"artist,omitempty"`
+		Copyright   string `json:"copyright,omitempty"`
+		GPS         *GPS   `json:"gps,omitempty"`
+		HasICC      bool   `json:"hasICC"`
+	}
+	GPS struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Altitude  float64 `json:"altitude"`
+	}
+
+	ifdReader struct {
+		b     []byte
+		order binary.ByteOrder
+	}
+)
+
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagArtist           = 0x013B
+	tagCopyright        = 0x8298
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagLensModel        = 0xA434
+
+	tagGPSLatitudeRef  = 1
+	tagGPSLatitude     = 2
+	tagGPSLongitudeRef = 3
+	tagGPSLongitude    = 4
+	tagGPSAltitudeRef  = 5
+	tagGPSAltitude     = 6
+)
+
+// ReadJPEG reads the EXIF and ICC segments of a JPEG, returns nil Metadata for other files
+func ReadJPEG(filename string) (*Metadata, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	soi := make([]byte, 2)
+	if _, e = io.ReadFull(r, soi); e != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, nil
+	}
+	m := new(Metadata)
+	for {
+		marker := make([]byte, 4)
+		_, e = io.ReadFull(r, marker)
+		if e != nil {
+			return nil, e
+		}
+		if marker[0] != 0xFF {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		// start of scan, there's no metadata after it
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return m, nil
+		}
+		size := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if size < 0 {
+			return nil, errors.New("invalid JPEG segment size")
+		}
+		seg := make([]byte, size)
+		_, e = io.ReadFull(r, seg)
+		if e != nil {
+			return nil, e
+		}
+		switch {
+		case marker[1] == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")):
+			e = m.parseTIFF(seg[6:])
+			if e != nil {
+				return nil, e
+			}
+		case marker[1] == 0xE2 && bytes.HasPrefix(seg, []byte("ICC_PROFILE\x00")):
+			m.HasICC = true
+		}
+	}
+}
+
+// TransposeFilter returns the ffmpeg filter that applies the orientation, empty if nothing to do
+func (m *Metadata) TransposeFilter() string {
+	if m == nil {
+		return ""
+	}
+	switch m.Orientation {
+	case 2:
+		return "hflip"
+	case 3:
+		return "hflip,vflip"
+	case 4:
+		return "vflip"
+	case 5:
+		return "transpose=cclock_flip"
+	case 6:
+		return "transpose=clock"
+	case 7:
+		return "transpose=clock_flip"
+	case 8:
+		return "transpose=cclock"
+	}
+	return ""
+}
+
+// SwapsSize reports whether the orientation turns the image by 90 degrees
+func (m *Metadata) SwapsSize() bool {
+	return m != nil && m.Orientation >= 5 && m.Orientation <= 8
+}
+
+func (m *Metadata) parseTIFF(b []byte) error {
+	if len(b) < 8 {
+		return errors.New("invalid TIFF header")
+	}
+	r := &ifdReader{b: b}
+	switch string(b[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return errors.New("invalid TIFF byte order")
+	}
+
+	var exifIFD, gpsIFD uint32
+	r.walk(r.order.Uint32(b[4:]), func(tag, typ uint16, count uint32, value []byte) {
+		switch tag {
+		case tagMake:
+			m.Make = r.ascii(value)
+		case tagModel:
+			m.Model = r.ascii(value)
+		case tagOrientation:
+			m.Orientation = int(r.order.Uint16(value))
+		case tagSoftware:
+			m.Software = r.ascii(value)
+		case tagDateTime:
+			m.DateTime = r.ascii(value)
+		case tagArtist:
+			m.Artist = r.ascii(value)
+		case tagCopyright:
+			m.Copyright = r.ascii(value)
+		case tagExifIFD:
+			exifIFD = r.order.Uint32(value)
+		case tagGPSIFD:
+			gpsIFD = r.order.Uint32(value)
+		}
+	})
+	if exifIFD != 0 {
+		r.walk(exifIFD, func(tag, typ uint16, count uint32, value []byte) {
+			switch tag {
+			case tagDateTimeOriginal:
+				m.DateTime = r.ascii(value)
+			case tagLensModel:
+				m.LensModel = r.ascii(value)
+			}
+		})
+	}
+	if gpsIFD != 0 {
+		gps := new(GPS)
+		var latRef, lonRef string
+		var altRef byte
+		found := false
+		r.walk(gpsIFD, func(tag, typ uint16, count uint32, value []byte) {
+			switch tag {
+			case tagGPSLatitudeRef:
+				latRef = r.ascii(value)
+			case tagGPSLatitude:
+				gps.Latitude, found = r.degrees(value, count), true
+			case tagGPSLongitudeRef:
+				lonRef = r.ascii(value)
+			case tagGPSLongitude:
+				gps.Longitude = r.degrees(value, count)
+			case tagGPSAltitudeRef:
+				altRef = value[0]
+			case tagGPSAltitude:
+				gps.Altitude = r.rational(value)
+			}
+		})
+		if found {
+			if latRef == "S" {
+				gps.Latitude = -gps.Latitude
+			}
+			if lonRef == "W" {
+				gps.Longitude = -gps.Longitude
+			}
+			if altRef == 1 {
+				gps.Altitude = -gps.Altitude
+			}
+			m.GPS = gps
+		}
+	}
+	return nil
+}
+
+var (
+	typeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+)
+
+// walk calls fn with the value bytes of every entry of the IFD at offset, malformed entries are skipped
+func (r *ifdReader) walk(offset uint32, fn func(tag, typ uint16, count uint32, value []byte)) {
+	if int(offset)+2 > len(r.b) {
+		return
+	}
+	n := int(r.order.Uint16(r.b[offset:]))
+	for i := 0; i < n; i++ {
+		p := int(offset) + 2 + i*12
+		if p+12 > len(r.b) {
+			return
+		}
+		entry := r.b[p : p+12]
+		tag := r.order.Uint16(entry)
+		typ := r.order.Uint16(entry[2:])
+		count := r.order.Uint32(entry[4:])
+		size := typeSizes[typ] * count
+		if size == 0 {
+			continue
+		}
+		value := entry[8:12]
+		if size > 4 {
+			start := r.order.Uint32(entry[8:])
+			if uint64(start)+uint64(size) > uint64(len(r.b)) {
+				continue
+			}
+			value = r.b[start : start+size]
+		}
+		fn(tag, typ, count, value)
+	}
+}
+
+func (r *ifdReader) ascii(value []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+}
+
+func (r *ifdReader) rational(value []byte) float64 {
+	if len(value) < 8 {
+		return 0
+	}
+	den := r.order.Uint32(value[4:])
+	if den == 0 {
+		return 0
+	}
+	return float64(r.order.Uint32(value)) / float64(den)
+}
+
+// degrees reads degrees, minutes and seconds
+func (r *ifdReader) degrees(value []byte, count uint32) float64 {
+	if count < 3 || len(value) < 24 {
+		return 0
+	}
+	return r.rational(value) + r.rational(value[8:])/60 + r.rational(value[16:])/3600
+}
+
+// Identifying reports whether there's location, device or time information
+func (m *Metadata) Identifying() bool {
+	return m != nil && (m.GPS != nil || m.Make != "" || m.Model != "" || m.LensModel != "" || m.Software != "" || m.DateTime != "")
+}
+
+// ParseTIFF reads EXIF in TIFF form, e.g. the Exif item of HEIF
+func ParseTIFF(b []byte) (*Metadata, error) {
+	m := new(Metadata)
+	e := m.parseTIFF(b)
+	if e != nil {
+		return nil, e
+	}
+	return m, nil
+}
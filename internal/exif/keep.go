@@ -0,0 +1,46 @@
+package exif
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+const (
+	KEEP_COPYRIGHT = "copyright" // Copyright and Artist
+	KEEP_ICC       = "icc"
+)
+
+var (
+	keepTags = map[string][]string{
+		KEEP_COPYRIGHT: {"-Copyright", "-Artist"},
+		KEEP_ICC:       {"-ICC_Profile"},
+	}
+	ErrNoExiftool = errors.New("exiftool isn't installed")
+)
+
+func ValidateKeep(keep string) error {
+	if _, ok := keepTags[keep]; !ok {
+		return errors.New("unsupported metadata to keep:" + keep)
+	}
+	return nil
+}
+
+// CopyTags copies the kept tags of src into dst, the outputs are stripped of everything else by ffmpeg
+//
+// exiftool -overwrite_original -tagsFromFile a.jpg -Copyright -Artist -ICC_Profile a.avif
+func CopyTags(dst, src string, keep []string) error {
+	if len(keep) == 0 {
+		return nil
+	}
+	if _, e := exec.LookPath("exiftool"); e != nil {
+		return ErrNoExiftool
+	}
+	args := []string{"-overwrite_original", "-tagsFromFile", src}
+	for _, k := range keep {
+		args = append(args, keepTags[k]...)
+	}
+	_, e := cmdToolkit.Run("exiftool", append(args, dst)...)
+	return e
+}
@@ -0,0 +1,56 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+var (
+	// APP1 EXIF/XMP, APP2 ICC/MPF, APP13 IPTC and comments, APP14 of Adobe is kept since it tells the color transform
+	metadataMarkers = map[byte]bool{0xE1: true, 0xE2: true, 0xED: true, 0xFE: true}
+)
+
+// StripJPEG copies a JPEG without its metadata segments, the image data is kept bit by bit,
+// so the copy can still be recompressed into JPEG XL losslessly
+func StripJPEG(dst, src string) error {
+	b, e := os.ReadFile(src)
+	if e != nil {
+		return e
+	}
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return errors.New("invalid JPEG header")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(b)))
+	out.Write(b[:2])
+	i := 2
+	for {
+		if i+2 > len(b) || b[i] != 0xFF {
+			return errors.New("invalid JPEG marker")
+		}
+		marker := b[i+1]
+		// fill bytes
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		// start of scan, only entropy-coded data follows
+		if marker == 0xDA || marker == 0xD9 {
+			out.Write(b[i:])
+			break
+		}
+		if i+4 > len(b) {
+			return errors.New("invalid JPEG segment size")
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(b[i+2:]))
+		if end < i+4 || end > len(b) {
+			return errors.New("invalid JPEG segment size")
+		}
+		if !metadataMarkers[marker] {
+			out.Write(b[i:end])
+		}
+		i = end
+	}
+	return os.WriteFile(dst, out.Bytes(), 0644)
+}
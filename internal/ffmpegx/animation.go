@@ -0,0 +1,90 @@
+package ffmpegx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+)
+
+func (m *MediaInfo) IsAnimated() bool {
+	return m.Frames > 1
+}
+
+// ProbeAnimation returns the frame count and the loop count (total plays, 0 is infinite) of a GIF or APNG,
+// other images are reported as 1 frame
+func ProbeAnimation(filename string) (int, int, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return 0, 0, e
+	}
+	defer f.Close()
+	// loop extension of GIF and acTL of APNG both come before the first frame
+	head := make([]byte, 64*1024)
+	n, e := io.ReadFull(f, head)
+	if e != nil && e != io.ErrUnexpectedEOF {
+		return 0, 0, e
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("GIF8")):
+		// without NETSCAPE2.0 extension, a GIF plays once
+		loop := 1
+		if i := bytes.Index(head, []byte("NETSCAPE2.0")); i >= 0 && i+15 <= len(head) && head[i+11] == 3 && head[i+12] == 1 {
+			repeat := int(binary.LittleEndian.Uint16(head[i+13:]))
+			if repeat == 0 {
+				loop = 0
+			} else {
+				loop = repeat + 1
+			}
+		}
+		frames, e := countFrames(filename)
+		if e != nil {
+			return 0, 0, e
+		}
+		return frames, loop, nil
+	case bytes.HasPrefix(head, pngSignature):
+		i := bytes.Index(head, []byte("acTL"))
+		if i < 0 || i+12 > len(head) {
+			return 1, 0, nil
+		}
+		frames := binary.BigEndian.Uint32(head[i+4:])
+		plays := binary.BigEndian.Uint32(head[i+8:])
+		return int(frames), int(plays), nil
+	}
+	return 1, 0, nil
+}
+
+// ffprobe -v error -count_frames -select_streams v:0 -show_entries stream=nb_read_frames -of csv=p=0 a.gif
+func countFrames(filename string) (int, error) {
+	output, e := cmdToolkit.Run("ffprobe", "-v", "error", "-count_frames", "-select_streams", "v:0", "-show_entries", "stream=nb_read_frames", "-of", "csv=p=0", filename)
+	if e != nil {
+		return 0, e
+	}
+	return strconv.Atoi(strings.TrimSpace(output))
+}
+
+// CompressAnimated encodes an animated GIF/APNG into an animated AVIF or WebP, by the extension of dst
+//
+// ffmpeg -i a.gif -map_metadata -1 -vf scale=480:270 -c:v libwebp_anim -compression_level 4 -q:v 75 -loop 0 a.webp
+func CompressAnimated(dst, filename, vf string, loop int, enc *ImageEncoding) error {
+	// all metadata is stripped, like stills
+	args := append([]string{"-y", "-i", filename, "-map_metadata", "-1", "-vf", vf}, enc.AnimatedArgs(imageFormat(dst))...)
+	_, e := cmdToolkit.Run("ffmpeg", append(args, "-loop", strconv.Itoa(loop), dst)...)
+	return e
+}
+
+// ffmpeg -i a.gif -vf scale=480:270,format=yuv420p -c:v libx264 -an -movflags +faststart a.mp4
+func CompressAnimatedMP4(dst, filename, vf string) error {
+	_, e := cmdToolkit.Run("ffmpeg", "-y", "-i", filename, "-vf", vf+",format=yuv420p", "-c:v", "libx264", "-an", "-movflags", "+faststart", dst)
+	return e
+}
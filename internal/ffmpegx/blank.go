@@ -0,0 +1,182 @@
+package ffmpegx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+// BlankOptions is how mostly black or silent videos are flagged
+type BlankOptions struct {
+	MaxBlackRatio   float64 `json:"maxBlackRatio"`   // within 0-1, fully analyzed videos blacker than it are rejected, 0 only reports
+	MaxSilenceRatio float64 `json:"maxSilenceRatio"` // within 0-1, fully analyzed videos more silent than it are rejected, 0 only reports
+	TrimSilence     bool    `json:"trimSilence"`     // drops leading and trailing silence from the encode
+}
+
+// Interval is [Start, End) in seconds
+type Interval struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+const (
+	BLANK_DETECT_WIDTH     = 320 // frames are checked downscaled, which is much faster
+	BLACK_MIN_DURATION     = 0.5 // seconds
+	BLACK_PIXEL_THRESHOLD  = 0.1 // luminance below which a pixel is black
+	SILENCE_MIN_DURATION   = 0.5 // seconds
+	SILENCE_NOISE          = "-50dB"
+	SILENCE_TRIM_TOLERANCE = 0.05 // seconds, silence starting or ending this close to the edges is leading or trailing
+	BLANK_SAMPLES          = 3    // windows analyzed across longer videos, the first and last ones at the edges
+	BLANK_SAMPLE_LENGTH    = 40   // seconds of each window
+)
+
+var (
+	// [blackdetect @ 0x5581] black_start:0 black_end:2.002 black_duration:2.002
+	blackRegex = regexp.MustCompile(`black_start:\s*([\d.]+)\s+black_end:\s*([\d.]+)`)
+	// [silencedetect @ 0x5581] silence_start: 1.234
+	// [silencedetect @ 0x5581] silence_end: 5.678 | silence_duration: 4.444
+	silenceRegex = regexp.MustCompile(`silence_(start|end):\s*(-?[\d.]+)`)
+)
+
+func (o BlankOptions) Validate() error {
+	if o.MaxBlackRatio < 0 || o.MaxBlackRatio > 1 {
+		return errors.New("blank maxBlackRatio must be within 0-1")
+	}
+	if o.MaxSilenceRatio < 0 || o.MaxSilenceRatio > 1 {
+		return errors.New("blank maxSilenceRatio must be within 0-1")
+	}
+	return nil
+}
+
+// Exceeded describes the ratio over its threshold, empty if none is.
+// Sampled analyses only report, windows of a mostly black video may all hit its content
+func (o BlankOptions) Exceeded(m *MediaInfo) string {
+	if m.BlankSampled {
+		return ""
+	}
+	if o.MaxBlackRatio > 0 && m.BlackRatio > o.MaxBlackRatio {
+		return fmt.Sprintf("%.0f%% black frames exceeds %.0f%%", m.BlackRatio*100, o.MaxBlackRatio*100)
+	}
+	if o.MaxSilenceRatio > 0 && m.SilenceRatio > o.MaxSilenceRatio {
+		return fmt.Sprintf("%.0f%% silence exceeds %.0f%%", m.SilenceRatio*100, o.MaxSilenceRatio*100)
+	}
+	return ""
+}
+
+// DetectBlank runs blackdetect, and silencedetect if there's audio, over the DurationSeconds of filename from start.
+// Videos longer than BLANK_SAMPLES windows are only analyzed in evenly spaced windows including the head and tail,
+// so it takes about as long whatever the length, which BlankSampled records.
+// The intervals are relative to start and the ratios to what's analyzed
+func (m *MediaInfo) DetectBlank(filename string, start float64) error {
+	m.BlackIntervals = []Interval{}
+	m.SilenceIntervals = []Interval{}
+	duration := float64(m.DurationSeconds)
+	if duration <= 0 {
+		return nil
+	}
+	offsets, length := []float64{0}, duration
+	m.BlankSampled = duration > BLANK_SAMPLES*BLANK_SAMPLE_LENGTH
+	if m.BlankSampled {
+		offsets, length = []float64{}, BLANK_SAMPLE_LENGTH
+		step := (duration - length) / (BLANK_SAMPLES - 1)
+		for i := 0; i < BLANK_SAMPLES; i++ {
+			offsets = append(offsets, float64(i)*step)
+		}
+	}
+	for _, offset := range offsets {
+		black, silence, e := m.detectBlankAt(filename, start+offset, length)
+		if e != nil {
+			return e
+		}
+		for _, i := range black {
+			m.BlackIntervals = append(m.BlackIntervals, Interval{offset + i.Start, offset + i.End})
+		}
+		for _, i := range silence {
+			m.SilenceIntervals = append(m.SilenceIntervals, Interval{offset + i.Start, offset + i.End})
+		}
+	}
+
+	analyzed := float64(len(offsets)) * length
+	m.BlackRatio = coverage(m.BlackIntervals, analyzed)
+	m.SilenceRatio = coverage(m.SilenceIntervals, analyzed)
+	return nil
+}
+
+// detectBlankAt analyzes length seconds of filename from start, the intervals are relative to start
+//
+// ffmpeg -ss 10 -t 40 -i a.mp4 -vf scale=320:-2,blackdetect=d=0.5:pix_th=0.1 -af silencedetect=n=-50dB:d=0.5 -f null -
+func (m *MediaInfo) detectBlankAt(filename string, start, length float64) (black, silence []Interval, e error) {
+	args := []string{"-ss", formatSeconds(start), "-t", formatSeconds(length), "-i", filename}
+	args = append(args, "-vf", fmt.Sprintf("scale=%d:-2,blackdetect=d=%s:pix_th=%s", BLANK_DETECT_WIDTH, formatFloat(BLACK_MIN_DURATION), formatFloat(BLACK_PIXEL_THRESHOLD)))
+	if m.HasAudio {
+		args = append(args, "-af", fmt.Sprintf("silencedetect=n=%s:d=%s", SILENCE_NOISE, formatFloat(SILENCE_MIN_DURATION)))
+	} else {
+		args = append(args, "-an")
+	}
+	output, e := cmdToolkit.Run("ffmpeg", append(args, "-f", "null", "-")...)
+	if e != nil {
+		return nil, nil, fmt.Errorf("%w: %s", e, output)
+	}
+
+	for _, match := range blackRegex.FindAllStringSubmatch(output, -1) {
+		start, _ := strconv.ParseFloat(match[1], 64)
+		end, _ := strconv.ParseFloat(match[2], 64)
+		black = append(black, Interval{start, math.Min(end, length)})
+	}
+
+	open := -1.0
+	for _, match := range silenceRegex.FindAllStringSubmatch(output, -1) {
+		t, _ := strconv.ParseFloat(match[2], 64)
+		if match[1] == "start" {
+			open = math.Max(t, 0)
+			continue
+		}
+		if open >= 0 {
+			silence = append(silence, Interval{open, math.Min(t, length)})
+			open = -1
+		}
+	}
+	// silence lasting till the end of the window has no silence_end
+	if open >= 0 && open < length {
+		silence = append(silence, Interval{open, length})
+	}
+	return black, silence, nil
+}
+
+// SilenceTrim returns the start and end of what's left without leading and trailing silence, ok is false if there's nothing to trim
+func (m *MediaInfo) SilenceTrim() (start, end float64, ok bool) {
+	start, end = 0, float64(m.DurationSeconds)
+	if n := len(m.SilenceIntervals); n > 0 {
+		if first := m.SilenceIntervals[0]; first.Start <= SILENCE_TRIM_TOLERANCE {
+			start = first.End
+		}
+		if last := m.SilenceIntervals[n-1]; last.End >= end-SILENCE_TRIM_TOLERANCE {
+			end = last.Start
+		}
+	}
+	// fully silent videos are kept as they are
+	if end-start < SILENCE_MIN_DURATION {
+		return 0, 0, false
+	}
+	return start, end, start > 0 || end < float64(m.DurationSeconds)
+}
+
+// coverage is the ratio of duration covered by intervals, within 0-1
+func coverage(intervals []Interval, duration float64) float64 {
+	if duration <= 0 {
+		return 0
+	}
+	total := 0.0
+	for _, i := range intervals {
+		total += i.End - i.Start
+	}
+	return round3(math.Min(total/duration, 1))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,130 @@
+package ffmpegx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ChapterOptions is how videos are split into chapters at scene changes
+type ChapterOptions struct {
+	Threshold float64 `json:"threshold"` // scene change score within 0-1, 0.4 by default
+	MinLength float64 `json:"minLength"` // seconds, scene changes closer to the previous chapter are skipped, 30 by default
+	Metadata  bool    `json:"metadata"`  // writes the chapters into the MP4 outputs
+	VTT       bool    `json:"vtt"`       // writes a WebVTT chapters track
+}
+
+// Chapter is a part of a video between scene changes
+type Chapter struct {
+	Start     float64 `json:"start"` // seconds
+	End       float64 `json:"end"`
+	Title     string  `json:"title"`
+	Thumbnail string  `json:"thumbnail"` // url of its first frame
+}
+
+const (
+	SCENE_DETECT_WIDTH = 320 // frames are scored downscaled, which is much faster
+	MAX_CHAPTERS       = 100
+)
+
+var (
+	sceneTimeRegex = regexp.MustCompile(`pts_time:([\d.]+)`)
+)
+
+func (o ChapterOptions) WithDefaults() ChapterOptions {
+	if o.Threshold <= 0 {
+		o.Threshold = 0.4
+	}
+	if o.MinLength <= 0 {
+		o.MinLength = 30
+	}
+	return o
+}
+
+func (o ChapterOptions) Validate() error {
+	if o.Threshold < 0 || o.Threshold > 1 {
+		return errors.New("chapter threshold must be within 0-1")
+	}
+	if o.MinLength < 0 {
+		return errors.New("chapter minLength can't be negative")
+	}
+	return nil
+}
+
+// DetectScenes returns the seconds of scene changes in [start, start+length), relative to start, it stops once ctx is done
+//
+// ffmpeg -ss 0 -t 600 -i a.mp4 -an -vf scale=320:-2,select='gt(scene,0.4)',metadata=print -f null -
+func DetectScenes(ctx context.Context, filename string, start, length, threshold float64) ([]float64, error) {
+	vf := fmt.Sprintf("scale=%d:-2,select='gt(scene,%s)',metadata=print", SCENE_DETECT_WIDTH, strconv.FormatFloat(threshold, 'f', -1, 64))
+	output, e := runContext(ctx, "ffmpeg", "-ss", formatSeconds(start), "-t", formatSeconds(length), "-i", filename, "-an", "-vf", vf, "-f", "null", "-")
+	if e != nil {
+		return nil, fmt.Errorf("%w: %s", e, output)
+	}
+	// [Parsed_metadata_2 @ 0x5581] frame:0    pts:45045   pts_time:45.045
+	scenes := []float64{}
+	for _, m := range sceneTimeRegex.FindAllStringSubmatch(output, -1) {
+		t, e := strconv.ParseFloat(m[1], 64)
+		if e != nil {
+			return nil, e
+		}
+		scenes = append(scenes, t)
+	}
+	return scenes, nil
+}
+
+// Chapters splits a video lasting duration seconds at scenes, chapters are at least MinLength long except the last one
+func (o ChapterOptions) Chapters(scenes []float64, duration float64) []Chapter {
+	starts := []float64{0}
+	for _, t := range scenes {
+		if len(starts) >= MAX_CHAPTERS {
+			break
+		}
+		if t-starts[len(starts)-1] >= o.MinLength && t < duration {
+			starts = append(starts, t)
+		}
+	}
+	chapters := []Chapter{}
+	for i, start := range starts {
+		end := duration
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		chapters = append(chapters, Chapter{
+			Start: math.Round(start*1000) / 1000,
+			End:   math.Round(end*1000) / 1000,
+			Title: "Chapter " + strconv.Itoa(i+1),
+		})
+	}
+	return chapters
+}
+
+// WriteChapterMetadata writes chapters in the FFMETADATA format, an input of `-map_chapters`
+func WriteChapterMetadata(dst string, chapters []Chapter) error {
+	b := new(strings.Builder)
+	b.WriteString(";FFMETADATA1\n")
+	for _, c := range chapters {
+		fmt.Fprintf(b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", int64(c.Start*1000), int64(c.End*1000), escapeMetadata(c.Title))
+	}
+	return os.WriteFile(dst, []byte(b.String()), 0644)
+}
+
+// escapeMetadata escapes the special characters of FFMETADATA values
+func escapeMetadata(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+	return r.Replace(s)
+}
+
+// WriteChapterVTT writes chapters as a WebVTT track, for <track kind="chapters">
+func WriteChapterVTT(dst string, chapters []Chapter) error {
+	b := new(strings.Builder)
+	b.WriteString("WEBVTT\n")
+	for _, c := range chapters {
+		fmt.Fprintf(b, "\n%s --> %s\n%s\n", formatVTTTime(c.Start), formatVTTTime(c.End), c.Title)
+	}
+	return os.WriteFile(dst, []byte(b.String()), 0644)
+}
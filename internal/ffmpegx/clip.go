@@ -0,0 +1,93 @@
+package ffmpegx
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/StevenZack/transcoder/internal/tools"
+)
+
+// Clip selects [Start, Start+Duration) of the input in seconds, zero Duration means till the end
+type Clip struct {
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// ParseClip parses the start and end (or duration) timestamps of a submission, returns nil if none is set
+func ParseClip(start, end, duration string) (*Clip, error) {
+	if start == "" && end == "" && duration == "" {
+		return nil, nil
+	}
+	c := new(Clip)
+	var e error
+	if start != "" {
+		c.Start, e = tools.ParseTimestamp(start)
+		if e != nil {
+			return nil, e
+		}
+	}
+	switch {
+	case end != "" && duration != "":
+		return nil, errors.New("end and duration can't be set at the same time")
+	case end != "":
+		t, e := tools.ParseTimestamp(end)
+		if e != nil {
+			return nil, e
+		}
+		if t <= c.Start {
+			return nil, errors.New("end must be after start")
+		}
+		c.Duration = t - c.Start
+	case duration != "":
+		c.Duration, e = tools.ParseTimestamp(duration)
+		if e != nil {
+			return nil, e
+		}
+		if c.Duration == 0 {
+			return nil, errors.New("duration must be positive")
+		}
+	}
+	return c, nil
+}
+
+// InputArgs returns the seeking options, they must be placed before `-i` so that ffmpeg seeks accurately on the input
+func (c *Clip) InputArgs() []string {
+	args := []string{}
+	if c == nil {
+		return args
+	}
+	if c.Start > 0 {
+		args = append(args, "-ss", formatSeconds(c.Start))
+	}
+	if c.Duration > 0 {
+		args = append(args, "-t", formatSeconds(c.Duration))
+	}
+	return args
+}
+
+// StartAt returns the start of the clip, 0 if there's no clip
+func (c *Clip) StartAt() float64 {
+	if c == nil {
+		return 0
+	}
+	return c.Start
+}
+
+// Length returns the clipped length of a media lasting total seconds
+func (c *Clip) Length(total float64) int {
+	if c == nil {
+		return int(total)
+	}
+	remain := total - c.Start
+	if c.Duration > 0 && c.Duration < remain {
+		remain = c.Duration
+	}
+	if remain < 0 {
+		return 0
+	}
+	return int(remain)
+}
+
+func formatSeconds(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
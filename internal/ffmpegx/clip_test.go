@@ -0,0 +1,58 @@
+package ffmpegx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseClip(t *testing.T) {
+	cases := []struct {
+		name                 string
+		start, end, duration string
+		want                 *Clip
+		ok                   bool
+	}{
+		{"none", "", "", "", nil, true},
+		{"start only", "1:20.5", "", "", &Clip{Start: 80.5}, true},
+		{"start and end", "10", "00:00:25", "", &Clip{Start: 10, Duration: 15}, true},
+		{"start and duration", "10", "", "5.5", &Clip{Start: 10, Duration: 5.5}, true},
+		{"end only", "", "30", "", &Clip{Duration: 30}, true},
+		{"end and duration", "", "30", "5", nil, false},
+		{"end before start", "30", "10", "", nil, false},
+		{"zero duration", "10", "", "0", nil, false},
+		{"negative", "-1", "", "", nil, false},
+		{"NaN", "NaN", "", "", nil, false},
+		{"Inf", "", "+Inf", "", nil, false},
+		{"too many parts", "1:2:3:4", "", "", nil, false},
+	}
+	for _, c := range cases {
+		clip, e := ParseClip(c.start, c.end, c.duration)
+		if (e == nil) != c.ok {
+			t.Errorf("%s: got %v, want ok %v", c.name, e, c.ok)
+			continue
+		}
+		if c.ok && !reflect.DeepEqual(clip, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, clip, c.want)
+		}
+	}
+}
+
+func TestClipLength(t *testing.T) {
+	cases := []struct {
+		name  string
+		clip  *Clip
+		total float64
+		want  int
+	}{
+		{"no clip", nil, 10.9, 10},
+		{"till the end", &Clip{Start: 4}, 10.9, 6},
+		{"shorter than the rest", &Clip{Start: 4, Duration: 3}, 10.9, 3},
+		{"longer than the rest", &Clip{Start: 4, Duration: 30}, 10.9, 6},
+		{"past the end", &Clip{Start: 12}, 10.9, 0},
+	}
+	for _, c := range cases {
+		if got := c.clip.Length(c.total); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
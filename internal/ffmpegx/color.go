@@ -0,0 +1,62 @@
+package ffmpegx
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	HDR_SDR         = "sdr"         // tone-map HDR sources into bt709 SDR, the default
+	HDR_PASSTHROUGH = "passthrough" // keep HDR as is, in 10 bits
+
+	TRC_PQ  = "smpte2084"
+	TRC_HLG = "arib-std-b67"
+
+	// linearize, tone-map in RGB, then convert back to limited range bt709
+	TONEMAP_FILTER = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+)
+
+var (
+	// yuv420p10le(tv, bt2020nc/bt2020/arib-std-b67)
+	pixFmtRegexp = regexp.MustCompile(`, (\w+)\(([^)]*)\)`)
+
+	SDR_COLOR_ARGS = []string{"-color_primaries", "bt709", "-color_trc", "bt709", "-colorspace", "bt709", "-color_range", "tv"}
+)
+
+// CanTonemap reports whether ffmpeg is built with zscale (libzimg), which TONEMAP_FILTER needs
+func CanTonemap() bool {
+	return HasFilter("zscale")
+}
+
+func (m *MediaInfo) IsHDR() bool {
+	return m.ColorTransfer == TRC_PQ || m.ColorTransfer == TRC_HLG
+}
+
+// HDRColorArgs returns the output options that keep the HDR color metadata of the source
+func (m *MediaInfo) HDRColorArgs() []string {
+	return []string{"-pix_fmt", "yuv420p10le", "-color_primaries", m.ColorPrimaries, "-color_trc", m.ColorTransfer, "-colorspace", m.ColorSpace, "-color_range", "tv"}
+}
+
+// parseColor reads the color properties from the pix_fmt part of a `Video:` stream line,
+// e.g. `yuv420p10le(tv, bt2020nc/bt2020/arib-std-b67)` or `yuv420p(tv, bt709)` when they're all the same
+func (m *MediaInfo) parseColor(line string) {
+	match := pixFmtRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	for _, item := range strings.Split(match[2], ", ") {
+		switch item {
+		case "tv", "pc":
+			m.ColorRange = item
+		case "progressive", "top first", "bottom first", "top coded first (swapped)", "bottom coded first (swapped)":
+		default:
+			ss := strings.Split(item, "/")
+			switch len(ss) {
+			case 1:
+				m.ColorSpace, m.ColorPrimaries, m.ColorTransfer = item, item, item
+			case 3:
+				m.ColorSpace, m.ColorPrimaries, m.ColorTransfer = ss[0], ss[1], ss[2]
+			}
+		}
+	}
+}
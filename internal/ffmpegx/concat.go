@@ -0,0 +1,73 @@
+package ffmpegx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Segment is one input of a concatenation
+type Segment struct {
+	Filename string
+	Image    bool    // images are looped for Duration seconds
+	Duration float64 // seconds
+	HasAudio bool
+}
+
+const (
+	OUTPUT_FPS = 10
+)
+
+var (
+	// link labels within a filter, like those of the blur pad
+	linkLabelRegex = regexp.MustCompile(`\[([a-z]+[0-9]*)\]`)
+)
+
+// VideoFilterArgs returns the filter options of a single input encode
+func VideoFilterArgs(vf string) []string {
+	return []string{"-vf", vf + ",fps=" + strconv.Itoa(OUTPUT_FPS)}
+}
+
+// ConcatInputArgs returns the `-i` options of all segments, in order
+func ConcatInputArgs(segs []Segment) []string {
+	args := []string{}
+	for _, seg := range segs {
+		if seg.Image {
+			args = append(args, "-loop", "1", "-framerate", strconv.Itoa(OUTPUT_FPS), "-t", formatSeconds(seg.Duration))
+		}
+		args = append(args, "-i", seg.Filename)
+	}
+	return args
+}
+
+// ConcatFilterArgs joins segs into one video and one mono audio stream, vfs[i] must normalize segs[i] to the same size
+//
+// [0:v]scale=400:400,fps=10,format=yuv420p,setsar=1[v0];[0:a]aresample=44100,aformat=sample_fmts=fltp:channel_layouts=mono[a0];...[v0][a0][v1][a1]concat=n=2:v=1:a=1[v][a]
+func ConcatFilterArgs(segs []Segment, vfs []string) []string {
+	b := new(strings.Builder)
+	for i, seg := range segs {
+		fmt.Fprintf(b, "[%d:v]%s,fps=%d,format=yuv420p,setsar=1[v%d];", i, inputLabels(vfs[i], i), OUTPUT_FPS, i)
+		if seg.HasAudio {
+			fmt.Fprintf(b, "[%d:a]aresample=44100,aformat=sample_fmts=fltp:channel_layouts=mono[a%d];", i, i)
+		} else {
+			fmt.Fprintf(b, "anullsrc=r=44100:cl=mono,atrim=duration=%s[a%d];", formatSeconds(seg.Duration), i)
+		}
+	}
+	for i := range segs {
+		fmt.Fprintf(b, "[v%d][a%d]", i, i)
+	}
+	fmt.Fprintf(b, "concat=n=%d:v=1:a=1[v][a]", len(segs))
+	return []string{"-filter_complex", b.String(), "-map", "[v]", "-map", "[a]"}
+}
+
+// inputLabels suffixes the link labels of vf with the index of its input, since labels must be unique in a filter_complex
+// holding vf once per input
+func inputLabels(vf string, i int) string {
+	return linkLabelRegex.ReplaceAllString(vf, fmt.Sprintf("[${1}_%d]", i))
+}
+
+// PadTo letterboxes the output of vf into exactly w×h
+func PadTo(vf string, w, h int) string {
+	return fmt.Sprintf("%s,scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2,pad=%d:%d:(ow-iw)/2:(oh-ih)/2", vf, w, h, w, h)
+}
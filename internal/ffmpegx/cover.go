@@ -0,0 +1,93 @@
+package ffmpegx
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+	"github.com/StevenZack/transcoder/internal/tools"
+)
+
+// CoverOptions decides which frame of a video becomes its cover
+type CoverOptions struct {
+	Mode    string  `json:"mode"`    // first|time|percent|auto, first by default
+	Time    float64 `json:"time"`    // seconds, for time mode
+	Percent float64 `json:"percent"` // 0-100 of the duration, for percent mode
+}
+
+const (
+	COVER_FIRST   = "first"
+	COVER_TIME    = "time"
+	COVER_PERCENT = "percent"
+	COVER_AUTO    = "auto"
+
+	// seconds analyzed by the auto mode
+	AUTO_COVER_WINDOW = 60
+	// 2 frames per second, black frames (>90% pixels black) and blurry ones are skipped,
+	// then thumbnail picks the most representative one
+	AUTO_COVER_FILTER = "fps=2,blackframe=amount=0,metadata=select:key=lavfi.blackframe.pblack:value=90:function=less,blurdetect,metadata=select:key=lavfi.blur:value=8:function=less,thumbnail=120"
+)
+
+// ParseCover parses `first`, `auto`, a percentage like `25%` or a timestamp
+func ParseCover(s string) (*CoverOptions, error) {
+	switch {
+	case s == COVER_FIRST, s == COVER_AUTO:
+		return &CoverOptions{Mode: s}, nil
+	case strings.HasSuffix(s, "%"):
+		p, e := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if e != nil || !(p >= 0 && p <= 100) {
+			return nil, errors.New("Invalid cover percentage: " + s)
+		}
+		return &CoverOptions{Mode: COVER_PERCENT, Percent: p}, nil
+	}
+	t, e := tools.ParseTimestamp(s)
+	if e != nil {
+		return nil, e
+	}
+	return &CoverOptions{Mode: COVER_TIME, Time: t}, nil
+}
+
+func (o *CoverOptions) Validate() error {
+	switch o.Mode {
+	case "", COVER_FIRST, COVER_TIME, COVER_AUTO:
+	case COVER_PERCENT:
+		if o.Percent < 0 || o.Percent > 100 {
+			return errors.New("cover percent must be within 0-100")
+		}
+	default:
+		return errors.New("unsupported cover mode:" + o.Mode)
+	}
+	return nil
+}
+
+// Offset returns the seconds of the cover frame in a media lasting duration seconds, for time and percent modes
+func (o *CoverOptions) Offset(duration int) float64 {
+	var t float64
+	switch o.Mode {
+	case COVER_TIME:
+		t = o.Time
+	case COVER_PERCENT:
+		t = float64(duration) * o.Percent / 100
+	}
+	// keep away from the very end, there may be no frame to decode
+	return math.Max(0, math.Min(t, float64(duration)-1))
+}
+
+// ffmpeg -ss 00:00:15 -i a.mp4 -vf scale=400:224 -frames:v 1 cover.avif
+func CreateCoverAt(dst, filename string, at float64, vf string, enc *ImageEncoding) error {
+	args := append([]string{"-y", "-ss", formatSeconds(at), "-i", filename, "-vf", vf, "-frames:v", "1"}, enc.Args(imageFormat(dst))...)
+	_, e := cmdToolkit.Run("ffmpeg", append(args, dst)...)
+	return e
+}
+
+// CreateAutoCover picks a frame of [start, start+length) that's neither black nor blurry
+//
+// ffmpeg -ss 0 -t 60 -i a.mp4 -vf fps=2,blackframe=...,thumbnail=120,scale=400:224 -frames:v 1 cover.avif
+func CreateAutoCover(dst, filename string, start, length float64, vf string, enc *ImageEncoding) error {
+	args := []string{"-y", "-ss", formatSeconds(start), "-t", formatSeconds(length), "-i", filename, "-vf", AUTO_COVER_FILTER + "," + vf, "-frames:v", "1"}
+	args = append(args, enc.Args(imageFormat(dst))...)
+	_, e := cmdToolkit.Run("ffmpeg", append(args, dst)...)
+	return e
+}
@@ -0,0 +1,131 @@
+package ffmpegx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// VideoCRF is the CRF of each video output
+type VideoCRF struct {
+	AV1  int `json:"av1"`
+	HEVC int `json:"hevc"`
+}
+
+// CRFSample is the VMAF and bitrate of the sample segments encoded at a CRF
+type CRFSample struct {
+	CRF  int     `json:"crf"`
+	VMAF float64 `json:"vmaf"` // mean of the samples
+	Kbps int     `json:"kbps"`
+}
+
+// CRFSearch is how the CRF of an output was chosen, for auditing
+type CRFSearch struct {
+	Codec   string      `json:"codec"`
+	Target  float64     `json:"target"` // VMAF
+	CRF     int         `json:"crf"`    // chosen
+	Met     bool        `json:"met"`    // whether the target is reachable, the lowest candidate is chosen otherwise
+	Samples []CRFSample `json:"samples"`
+}
+
+const (
+	CODEC_AV1  = "libaom-av1"
+	CODEC_HEVC = "libx265"
+
+	CRF_SAMPLES       = 3 // segments spread over the video
+	CRF_SAMPLE_LENGTH = 4 // seconds
+)
+
+var (
+	DEFAULT_VIDEO_CRF = VideoCRF{AV1: 48, HEVC: 32}
+
+	// CRFs encoded for the search, the result is interpolated between them
+	CRF_CANDIDATES = map[string][]int{
+		CODEC_AV1:  {32, 40, 48, 56},
+		CODEC_HEVC: {22, 27, 32, 37},
+	}
+)
+
+// SearchCRF picks the highest CRF of codec, the lowest bitrate, whose VMAF meets target,
+// by encoding short samples of [start, start+duration) of filename at every candidate and interpolating between them.
+// vf and colorArgs are those of the output, samples are written next to prefix and removed, it stops once ctx is done
+func SearchCRF(ctx context.Context, prefix, filename string, start, duration float64, vf, codec string, target float64, colorArgs []string) (*CRFSearch, error) {
+	candidates, ok := CRF_CANDIDATES[codec]
+	if !ok {
+		return nil, errors.New("unsupported codec:" + codec)
+	}
+	offsets, length := sampleOffsets(start, duration)
+
+	out := &CRFSearch{Codec: codec, Target: target}
+	for _, crf := range candidates {
+		var vmaf, bytes float64
+		for i, offset := range offsets {
+			if e := ctx.Err(); e != nil {
+				return nil, e
+			}
+			dst := fmt.Sprintf("%s.crf%d.%d.mp4", prefix, crf, i)
+			input := []string{"-ss", formatSeconds(offset), "-t", formatSeconds(length), "-i", filename}
+			args := append(append([]string{"-y"}, input...), "-vf", vf+",fps="+strconv.Itoa(OUTPUT_FPS), "-an", "-c:v", codec, "-crf", strconv.Itoa(crf), "-b:v", "0")
+			output, e := runContext(ctx, "ffmpeg", append(append(args, colorArgs...), dst)...)
+			if e != nil {
+				os.Remove(dst)
+				return nil, fmt.Errorf("%w: %s", e, output)
+			}
+			report, e := MeasureQuality(dst, input, vf)
+			info, statErr := os.Stat(dst)
+			os.Remove(dst)
+			if e != nil {
+				return nil, e
+			}
+			if statErr != nil {
+				return nil, statErr
+			}
+			if report.VMAF == nil {
+				return nil, errors.New("CRF search needs ffmpeg with libvmaf")
+			}
+			vmaf += report.VMAF.Mean
+			bytes += float64(info.Size())
+		}
+		out.Samples = append(out.Samples, CRFSample{
+			CRF:  crf,
+			VMAF: round3(vmaf / float64(len(offsets))),
+			Kbps: int(bytes * 8 / 1000 / (length * float64(len(offsets)))),
+		})
+	}
+	out.CRF, out.Met = pickCRF(out.Samples, target)
+	return out, nil
+}
+
+// sampleOffsets returns the starts of the samples, centered in equal parts of the video, and their length
+func sampleOffsets(start, duration float64) ([]float64, float64) {
+	if duration <= CRF_SAMPLES*CRF_SAMPLE_LENGTH {
+		return []float64{start}, math.Max(duration, 1)
+	}
+	offsets := []float64{}
+	for i := 0; i < CRF_SAMPLES; i++ {
+		offsets = append(offsets, start+(float64(i)+0.5)*duration/CRF_SAMPLES-CRF_SAMPLE_LENGTH/2.0)
+	}
+	return offsets, CRF_SAMPLE_LENGTH
+}
+
+// pickCRF interpolates linearly between the samples around target, rounding down to stay above it
+func pickCRF(samples []CRFSample, target float64) (int, bool) {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].CRF < samples[j].CRF
+	})
+	if samples[0].VMAF < target {
+		return samples[0].CRF, false
+	}
+	for i := 1; i < len(samples); i++ {
+		a, b := samples[i-1], samples[i]
+		if b.VMAF >= target {
+			continue
+		}
+		return a.CRF + int(math.Floor((a.VMAF-target)*float64(b.CRF-a.CRF)/(a.VMAF-b.VMAF))), true
+	}
+	return samples[len(samples)-1].CRF, true
+}
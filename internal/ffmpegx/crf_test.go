@@ -0,0 +1,73 @@
+package ffmpegx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPickCRF(t *testing.T) {
+	samples := func() []CRFSample {
+		// unsorted on purpose, VMAF drops as the CRF rises
+		return []CRFSample{{CRF: 40, VMAF: 93}, {CRF: 32, VMAF: 97}, {CRF: 56, VMAF: 80}, {CRF: 48, VMAF: 89}}
+	}
+	cases := []struct {
+		name    string
+		target  float64
+		wantCRF int
+		wantMet bool
+	}{
+		{"unreachable", 98, 32, false},
+		{"lowest candidate exactly", 97, 32, true},
+		{"between the first two", 95, 36, true},
+		{"rounded down", 94, 38, true},
+		{"at a candidate", 93, 40, true},
+		{"between the last two", 85, 51, true},
+		{"every candidate meets it", 70, 56, true},
+	}
+	for _, c := range cases {
+		crf, met := pickCRF(samples(), c.target)
+		if crf != c.wantCRF || met != c.wantMet {
+			t.Errorf("%s: got %d %v, want %d %v", c.name, crf, met, c.wantCRF, c.wantMet)
+		}
+	}
+}
+
+func TestPickCRFSingleSample(t *testing.T) {
+	crf, met := pickCRF([]CRFSample{{CRF: 27, VMAF: 90}}, 85)
+	if crf != 27 || !met {
+		t.Errorf("got %d %v, want 27 true", crf, met)
+	}
+}
+
+func TestSampleOffsets(t *testing.T) {
+	cases := []struct {
+		name         string
+		start        float64
+		duration     float64
+		wantOffsets  []float64
+		wantDuration float64
+	}{
+		{"short video in one sample", 0, 10, []float64{0}, 10},
+		{"unknown duration", 5, 0, []float64{5}, 1},
+		{"centered in thirds", 0, 60, []float64{8, 28, 48}, CRF_SAMPLE_LENGTH},
+		{"relative to the clip", 100, 60, []float64{108, 128, 148}, CRF_SAMPLE_LENGTH},
+	}
+	for _, c := range cases {
+		offsets, length := sampleOffsets(c.start, c.duration)
+		if !reflect.DeepEqual(offsets, c.wantOffsets) || length != c.wantDuration {
+			t.Errorf("%s: got %v %v, want %v %v", c.name, offsets, length, c.wantOffsets, c.wantDuration)
+		}
+	}
+}
+
+func TestSearchCRFCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// stops before encoding any sample
+	_, e := SearchCRF(ctx, t.TempDir()+"/a", "missing.mp4", 0, 60, "scale=64:-2", CODEC_AV1, 95, nil)
+	if !errors.Is(e, context.Canceled) {
+		t.Errorf("got %v, want %v", e, context.Canceled)
+	}
+}
@@ -0,0 +1,65 @@
+package ffmpegx
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+var (
+	encodersOnce sync.Once
+	encoders     = map[string]bool{}
+)
+
+// HasEncoder reports whether the ffmpeg in PATH is built with the encoder, e.g. libjxl
+//
+// ffmpeg -hide_banner -encoders
+func HasEncoder(name string) bool {
+	encodersOnce.Do(func() {
+		output, e := cmdToolkit.Run("ffmpeg", "-hide_banner", "-encoders")
+		if e != nil {
+			return
+		}
+		//  V....D libjxl               libjxl JPEG XL (codec jpegxl)
+		for _, line := range strings.Split(output, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 {
+				encoders[fields[1]] = true
+			}
+		}
+	})
+	return encoders[name]
+}
+
+// HasProgram reports whether the program is in PATH
+func HasProgram(name string) bool {
+	_, e := exec.LookPath(name)
+	return e == nil
+}
+
+var (
+	filtersOnce sync.Once
+	filters     = map[string]bool{}
+)
+
+// HasFilter reports whether the ffmpeg in PATH is built with the filter, e.g. libvmaf
+//
+// ffmpeg -hide_banner -filters
+func HasFilter(name string) bool {
+	filtersOnce.Do(func() {
+		output, e := cmdToolkit.Run("ffmpeg", "-hide_banner", "-filters")
+		if e != nil {
+			return
+		}
+		//  ... libvmaf           VV->V      Calculate the VMAF between two video streams.
+		for _, line := range strings.Split(output, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 {
+				filters[fields[1]] = true
+			}
+		}
+	})
+	return filters[name]
+}
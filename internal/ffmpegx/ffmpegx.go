@@ -2,10 +2,11 @@ package ffmpegx
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
-	"os"
+	"math"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -18,9 +19,31 @@ import (
 
 type (
 	MediaInfo struct {
-		Width           int `json:"width"`
-		Height          int `json:"height"`
-		DurationSeconds int `json:"durationSeconds"`
+		Width           int  `json:"width"`  // displayed width
+		Height          int  `json:"height"` // displayed height
+		CodedWidth      int  `json:"codedWidth"`
+		CodedHeight     int  `json:"codedHeight"`
+		Rotation        int  `json:"rotation"` // clockwise degrees applied on display
+		DurationSeconds int  `json:"durationSeconds"`
+		HasAudio        bool `json:"hasAudio"`
+		Frames          int  `json:"frames,omitempty"`    // frame count of animated images
+		LoopCount       int  `json:"loopCount,omitempty"` // total plays of animated images, 0 is infinite
+
+		Duration float64 `json:"duration,omitempty"` // seconds of the whole input as probed, DurationSeconds is truncated and clipped
+
+		ColorRange     string `json:"colorRange,omitempty"`
+		ColorSpace     string `json:"colorSpace,omitempty"` // matrix coefficients
+		ColorPrimaries string `json:"colorPrimaries,omitempty"`
+		ColorTransfer  string `json:"colorTransfer,omitempty"`
+
+		FieldOrder string `json:"fieldOrder,omitempty"` // progressive|tff|bff, only set by the interlace analysis
+
+		// only set by the blank analysis
+		BlackIntervals   []Interval `json:"blackIntervals,omitempty"`
+		BlackRatio       float64    `json:"blackRatio,omitempty"` // within 0-1
+		SilenceIntervals []Interval `json:"silenceIntervals,omitempty"`
+		SilenceRatio     float64    `json:"silenceRatio,omitempty"` // within 0-1, videos without audio aren't analyzed
+		BlankSampled     bool       `json:"blankSampled,omitempty"` // only windows of long videos are analyzed, the ratios are of those
 	}
 )
 
@@ -44,15 +67,12 @@ func FitConstraint(widthConstraint, heightConstraint int, w, h int) (int, int) {
 	return widthConstraint, rh
 }
 
-// ffmpeg -i l.jpg -q:v 31 a.webp
-func CompressImage(dst, filename string) error {
-	_, e := cmdToolkit.Run("ffmpeg", "-y", "-i", filename, "-q:v", "31", dst)
-	return e
-}
-
-// ffmpeg -i a.mp4 -ss 00:00:15 -frames:v 1 cover.webp
-func CreateCoverOfVideo(dst, filename string, w, h int) error {
-	_, e := cmdToolkit.Run("ffmpeg", "-y", "-i", filename, "-vf", fmt.Sprintf("scale=%dx%d", w, h), "-frames:v", "1", "-q:v", "31", dst)
+// ffmpeg -ss 00:00:15 -i a.mp4 -frames:v 1 cover.webp
+func CreateCoverOfVideo(dst, filename string, clip *Clip, vf string, enc *ImageEncoding) error {
+	args := append([]string{"-y"}, clip.InputArgs()...)
+	args = append(append(args, "-i", filename, "-vf", vf, "-frames:v", "1"), enc.Args(imageFormat(dst))...)
+	args = append(args, dst)
+	_, e := cmdToolkit.Run("ffmpeg", args...)
 	return e
 }
 
@@ -60,49 +80,53 @@ func CreateCoverOfVideo(dst, filename string, w, h int) error {
 * ffmpeg -y -i a.mp4 -c:v libaom-av1 -vf scale=256x144,fps=10 -c:a aac -ac 1 -b:a 24k  -crf 42 -b:v 0 a.av1.mp4  -progress progress.txt &&
 ffmpeg -y -i a.mp4 -c:v libx265 -vf scale=640x360,fps=10 -c:a aac -ac 1 -b:a 24k  -crf 42 -b:v 0 a.hevc.mp4 -progress progress.txt
 */
-func CompressToAV1_HEVC(dstAV1, dstHEVC, originalFilename, progressFile string, wAV1, hAV1, wHEVC, hHEVC int) (**exec.Cmd, error) {
-	cmd := exec.Command("ffmpeg", "-y", "-i", originalFilename, "-c:v", "libaom-av1", "-vf", fmt.Sprintf("scale=%dx%d,fps=10", wAV1, hAV1), "-c:a", "aac", "-ac", "1", "-b:a", "24k", "-crf", "48", "-b:v", "0", "-progress", progressFile, dstAV1)
-	log.Println(cmd.String())
-	fo := new(strings.Builder)
-	fe := new(strings.Builder)
-	cmd.Stderr = fe
-	cmd.Stdout = fo
-
-	e := cmd.Start()
-	if e != nil {
-		return nil, e
-	}
-	go func() {
-		e := cmd.Wait()
-		if e != nil {
-			log.Println(e, cmd.String())
-			log.Println(fo.String() + fe.String())
-			return
+// input holds the input options including `-i`, filterAV1 and filterHEVC are the filter options of each output, see VideoFilterArgs and ConcatFilterArgs.
+// outputArgs are output options like color and chapter ones. Everything runs in background until ctx is done:
+// prepare, if not nil, runs first and returns the CRFs of the outputs, DEFAULT_VIDEO_CRF is used otherwise,
+// done, if not nil, is called with the result once both outputs are written or anything fails
+func CompressToAV1_HEVC(ctx context.Context, dstAV1, dstHEVC, progressFile string, input, filterAV1, filterHEVC, outputArgs []string, prepare func() (VideoCRF, error), done func(error)) {
+	input = append([]string{"-y"}, input...)
+	encode := func(dst, codec string, filter []string, crf int) error {
+		if e := ctx.Err(); e != nil {
+			return e
 		}
-
-		cmd = exec.Command(
-			"ffmpeg", "-y", "-i", originalFilename, "-c:v", "libx265", "-vf", fmt.Sprintf("scale=%dx%d,fps=10", wHEVC, hHEVC), "-c:a", "aac", "-ac", "1", "-b:a", "24k", "-crf", "32", "-b:v", "0", "-progress", progressFile, dstHEVC,
-		)
+		cmd := exec.CommandContext(ctx, "ffmpeg", append(append(input, filter...), "-c:v", codec, "-c:a", "aac", "-ac", "1", "-b:a", "24k", "-crf", strconv.Itoa(crf), "-b:v", "0")...)
+		cmd.Args = append(append(cmd.Args, outputArgs...), "-progress", progressFile, dst)
 		log.Println(cmd.String())
-
 		fo := new(strings.Builder)
 		fe := new(strings.Builder)
 		cmd.Stderr = fe
 		cmd.Stdout = fo
-		e = cmd.Start()
-		if e != nil {
-			log.Println(e)
-			return
-		}
-
-		e = cmd.Wait()
+		e := cmd.Run()
 		if e != nil {
 			log.Println(e, cmd.String())
 			log.Println(fo.String() + fe.String())
-			return
+			return fmt.Errorf("encode %s failed:%w", filepath.Base(dst), e)
+		}
+		return nil
+	}
+	go func() {
+		crf := DEFAULT_VIDEO_CRF
+		var e error
+		if prepare != nil {
+			crf, e = prepare()
+		}
+		if e == nil {
+			e = encode(dstAV1, CODEC_AV1, filterAV1, crf.AV1)
+		}
+		if e == nil {
+			e = encode(dstHEVC, CODEC_HEVC, filterHEVC, crf.HEVC)
+		}
+		if done != nil {
+			done(e)
 		}
 	}()
-	return &cmd, nil
+}
+
+// runContext runs a command like cmdToolkit.Run, killing it once ctx is done
+func runContext(ctx context.Context, name string, args ...string) (string, error) {
+	output, e := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	return string(output), e
 }
 
 // ffmpeg -i a.mp4 -c:v libx265 -vf scale=640x360,fps=10 -c:a aac -ac 1 -b:a 24k  -crf 42 -b:v 0 out.hevc.mp4
@@ -125,32 +149,6 @@ func compressToAV1(dst, filename, progressFile string, w, h int) (*exec.Cmd, err
 	return cmd, nil
 }
 
-func ProbeVideoAuto(filename string) (*MediaInfo, error) {
-	oneFrame := filename + ".1f" + filepath.Ext(filename)
-	e := cmdToolkit.RunAttach("ffmpeg", "-i", filename, "-frames:v", "1", oneFrame)
-	if e != nil {
-		log.Println(e)
-		return nil, e
-	}
-	defer os.Remove(oneFrame)
-
-	cover, e := ProbeMedia(oneFrame)
-	if e != nil {
-		log.Println(e)
-		return nil, e
-	}
-
-	info, e := ProbeMedia(filename)
-	if e != nil {
-		log.Println(e)
-		return nil, e
-	}
-	info.Width = cover.Width
-	info.Height = cover.Height
-
-	return info, nil
-}
-
 /*
 Stream #0:1[0x2](und): Video: h264 (High) (avc1 / 0x31637661), yuvj420p(pc, bt709/bt709/iec61966-2-1, progressive), 828x1792, 16366 kb/s, 58.90 fps, 60 tbr, 600 tbn (default)
 Metadata:
@@ -163,6 +161,8 @@ Side data:
 
 	displaymatrix: rotation of 90.00 degrees
 */
+// ProbeMedia reads the coded size and the rotation of the first video stream, Width and Height are the displayed size.
+// ffmpeg autorotates its input by default, so covers and video outputs are all laid out with the displayed size.
 func ProbeMedia(filename string) (*MediaInfo, error) {
 	output, e := cmdToolkit.Run("ffprobe", filename)
 	if e != nil {
@@ -174,12 +174,21 @@ func ProbeMedia(filename string) (*MediaInfo, error) {
 		return nil, errors.New("invalid output:" + output)
 	}
 
-	var width, height int
-	var dur int
+	info := new(MediaInfo)
+	inVideo := false
 	for _, s := range ss {
 		s = strings.TrimSpace(s)
-		if width == 0 && strings.HasPrefix(s, "Stream") && strings.Contains(s, "Video:") {
+		if strings.HasPrefix(s, "Stream") {
+			if strings.Contains(s, "Audio:") {
+				info.HasAudio = true
+			}
+			if inVideo || info.CodedWidth != 0 || !strings.Contains(s, "Video:") {
+				inVideo = false
+				continue
+			}
+			inVideo = true
 			s = strToolkit.SubAfter(s, "Video:", "")
+			info.parseColor(s)
 			for _, item := range strings.Split(s, ", ") {
 				item = strings.TrimSpace(item)
 				if !strings.Contains(item, "x") {
@@ -190,25 +199,49 @@ func ProbeMedia(filename string) (*MediaInfo, error) {
 				if len(vs) != 2 {
 					continue
 				}
-				width, e = strconv.Atoi(vs[0])
+				width, e := strconv.Atoi(vs[0])
 				if e != nil {
 					continue
 				}
-				height, e = strconv.Atoi(vs[1])
+				height, e := strconv.Atoi(vs[1])
 				if e != nil {
 					continue
 				}
+				info.CodedWidth, info.CodedHeight = width, height
 			}
 			continue
 		}
 
-		if dur == 0 && strings.HasPrefix(s, "Duration:") {
+		if inVideo {
+			switch {
+			case strings.HasPrefix(s, "displaymatrix:"):
+				// rotation of -90.00 degrees: counter-clockwise
+				deg := strToolkit.SubAfter(s, "rotation of ", "")
+				deg = strToolkit.SubBefore(deg, " ", deg)
+				f, e := strconv.ParseFloat(deg, 64)
+				if e == nil {
+					info.Rotation = normalizeRotation(-int(math.Round(f)))
+				}
+			case strings.HasPrefix(s, "rotate") && strings.Contains(s, ":"):
+				// legacy tag, clockwise
+				i, e := strconv.Atoi(strings.TrimSpace(strToolkit.SubAfter(s, ":", "")))
+				if e == nil {
+					info.Rotation = normalizeRotation(i)
+				}
+			}
+		}
+
+		if info.DurationSeconds == 0 && strings.HasPrefix(s, "Duration:") {
 			s = strToolkit.SubAfter(s, "Duration:", s)
 			s = strToolkit.SubBefore(s, ",", s)
 			s = strings.TrimSpace(s)
-			s = strToolkit.SubBeforeLast(s, ".", s)
 			if s != "N/A" {
-				dur, e = tools.ParseDurationSeconds(s)
+				info.Duration, e = tools.ParseTimestamp(s)
+				if e != nil {
+					return nil, fmt.Errorf("parse duration '%s' failed:%w", s, e)
+				}
+				s = strToolkit.SubBeforeLast(s, ".", s)
+				info.DurationSeconds, e = tools.ParseDurationSeconds(s)
 				if e != nil {
 					return nil, fmt.Errorf("parse duration '%s' failed:%w", s, e)
 				}
@@ -218,11 +251,29 @@ func ProbeMedia(filename string) (*MediaInfo, error) {
 		}
 	}
 
-	return &MediaInfo{
-		Width:           width,
-		Height:          height,
-		DurationSeconds: dur,
-	}, nil
+	info.Width, info.Height = info.CodedWidth, info.CodedHeight
+	if info.Rotation == 90 || info.Rotation == 270 {
+		info.Width, info.Height = info.CodedHeight, info.CodedWidth
+	}
+	return info, nil
+}
+
+// RotationFilter returns the filter applying Rotation, for inputs read with -noautorotate, empty if there's none
+func (m *MediaInfo) RotationFilter() string {
+	switch m.Rotation {
+	case 90:
+		return "transpose=clock"
+	case 180:
+		return "hflip,vflip"
+	case 270:
+		return "transpose=cclock"
+	}
+	return ""
+}
+
+// normalizeRotation maps degrees into [0, 360)
+func normalizeRotation(deg int) int {
+	return (deg%360 + 360) % 360
 }
 
 // ProbeAudio returns audio ext,duration
@@ -291,3 +342,14 @@ func trimPrefix(s string) string {
 	}
 	return strToolkit.TrimEnds(b.String(), "\n")
 }
+
+// ProbeFormat returns the demuxers ffprobe reads the file with, e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+//
+// ffprobe -v error -show_entries format=format_name -of default=nw=1:nk=1 a.mp4
+func ProbeFormat(filename string) (string, error) {
+	output, e := cmdToolkit.Run("ffprobe", "-v", "error", "-show_entries", "format=format_name", "-of", "default=nw=1:nk=1", filename)
+	if e != nil {
+		return "", fmt.Errorf("%w: %s", e, output)
+	}
+	return strings.TrimSpace(output), nil
+}
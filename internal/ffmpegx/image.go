@@ -0,0 +1,214 @@
+package ffmpegx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+// ImageEncoding is the encoder settings of one image format, the same scales apply to every format
+type ImageEncoding struct {
+	Quality  int    `json:"quality"`  // 1-100, higher is better
+	Lossless bool   `json:"lossless"` // e.g. for screenshots, Quality is ignored
+	Effort   int    `json:"effort"`   // 1-10, higher is slower and smaller
+	Chroma   string `json:"chroma"`   // 420|444 subsampling, lossy WebP is always 420, JPEG XL is always 444
+
+	Recompressed bool `json:"recompressed,omitempty"` // JPEG XL losslessly recompressed from a JPEG, other fields are ignored
+}
+
+const (
+	CHROMA_420 = "420"
+	CHROMA_444 = "444"
+)
+
+var (
+	DEFAULT_IMAGE_ENCODINGS = map[string]ImageEncoding{
+		"avif": {Quality: 50, Effort: 5, Chroma: CHROMA_420},
+		"webp": {Quality: 75, Effort: 7, Chroma: CHROMA_420},
+		"jxl":  {Quality: 75, Effort: 7, Chroma: CHROMA_444},
+	}
+)
+
+func (enc *ImageEncoding) Validate(format string) error {
+	e := ValidateImageFormat(format)
+	if e != nil {
+		return e
+	}
+	if enc.Quality < 0 || enc.Quality > 100 {
+		return errors.New("image quality must be within 1-100")
+	}
+	if enc.Effort < 0 || enc.Effort > 10 {
+		return errors.New("image effort must be within 1-10")
+	}
+	switch enc.Chroma {
+	case "", CHROMA_420, CHROMA_444:
+	default:
+		return errors.New("unsupported chroma subsampling:" + enc.Chroma)
+	}
+	return nil
+}
+
+func ValidateImageFormat(format string) error {
+	if _, ok := DEFAULT_IMAGE_ENCODINGS[format]; !ok {
+		return errors.New("unsupported image format:" + format)
+	}
+	if format == "jxl" && !HasEncoder("libjxl") {
+		return errors.New("ffmpeg isn't built with libjxl")
+	}
+	return nil
+}
+
+// WithDefaults fills the unset fields with the defaults of format, and resolves the chroma subsampling actually used
+func (enc ImageEncoding) WithDefaults(format string) ImageEncoding {
+	def := DEFAULT_IMAGE_ENCODINGS[format]
+	if enc.Quality <= 0 {
+		enc.Quality = def.Quality
+	}
+	if enc.Effort <= 0 {
+		enc.Effort = def.Effort
+	}
+	if enc.Chroma == "" {
+		enc.Chroma = def.Chroma
+	}
+	switch {
+	case enc.Lossless, format == "jxl":
+		enc.Chroma = CHROMA_444
+	case format == "webp":
+		enc.Chroma = CHROMA_420
+	}
+	return enc
+}
+
+// Args returns the encoder options of format, enc must have defaults filled
+func (enc *ImageEncoding) Args(format string) []string {
+	return enc.args(format, false)
+}
+
+// AnimatedArgs returns the encoder options of animated AVIF and WebP, enc must have defaults filled
+func (enc *ImageEncoding) AnimatedArgs(format string) []string {
+	return enc.args(format, true)
+}
+
+func (enc *ImageEncoding) args(format string, animated bool) []string {
+	pixFmt := "yuv" + enc.Chroma + "p"
+	switch format {
+	case "avif":
+		// libaom: crf 0-63, cpu-used 8-0
+		args := []string{"-c:v", "libaom-av1", "-cpu-used", strconv.Itoa(8 - enc.Effort*8/10), "-pix_fmt", pixFmt}
+		if !animated {
+			args = append(args, "-still-picture", "1")
+		}
+		if enc.Lossless {
+			return append(args, "-crf", "0", "-b:v", "0", "-aom-params", "lossless=1")
+		}
+		return append(args, "-crf", strconv.Itoa(63-enc.Quality*63/100), "-b:v", "0")
+	case "webp":
+		// libwebp: q 0-100, compression_level 0-6
+		codec := "libwebp"
+		if animated {
+			codec = "libwebp_anim"
+		}
+		args := []string{"-c:v", codec, "-compression_level", strconv.Itoa(enc.Effort * 6 / 10)}
+		if enc.Lossless {
+			return append(args, "-lossless", "1")
+		}
+		args = append(args, "-q:v", strconv.Itoa(enc.Quality))
+		if animated {
+			// the encoder picks the pixel format, which keeps transparency of GIFs
+			return args
+		}
+		return append(args, "-pix_fmt", pixFmt)
+	case "jxl":
+		// libjxl: distance 0 (lossless)-15, effort 1-9
+		args := []string{"-c:v", "libjxl", "-effort", strconv.Itoa(1 + enc.Effort*8/10)}
+		if enc.Lossless {
+			return append(args, "-distance", "0")
+		}
+		return append(args, "-distance", strconv.FormatFloat(jxlDistance(enc.Quality), 'f', 2, 64))
+	}
+	return nil
+}
+
+// imageFormat returns the format of an image output by its extension, e.g. avif
+func imageFormat(dst string) string {
+	return strings.TrimPrefix(filepath.Ext(dst), ".")
+}
+
+// jxlDistance maps quality into butteraugli distance the same way as cjxl does
+func jxlDistance(quality int) float64 {
+	if quality >= 30 {
+		return 0.1 + float64(100-quality)*0.09
+	}
+	return 6.4 + math.Pow(2.5, float64(30-quality)/5)/6.25
+}
+
+// RecompressJPEG transcodes a JPEG into JPEG XL losslessly, the JPEG can be reconstructed bit by bit
+//
+// cjxl a.jpg a.jxl --lossless_jpeg=1 -e 7
+func RecompressJPEG(dst, filename string, enc *ImageEncoding) error {
+	_, e := cmdToolkit.Run("cjxl", filename, dst, "--lossless_jpeg=1", "-e", strconv.Itoa(1+enc.Effort*8/10))
+	return e
+}
+
+// ffmpeg -noautorotate -i l.jpg -map_metadata -1 -vf transpose=clock,scale=1080:1080 -c:v libwebp -compression_level 4 -q:v 75 -pix_fmt yuv420p a.webp
+func CompressImage(dst, filename, vf string, enc *ImageEncoding) error {
+	format := imageFormat(dst)
+	// orientation is applied by vf, and all metadata is stripped
+	args := append([]string{"-y", "-noautorotate", "-i", filename, "-map_metadata", "-1", "-vf", vf}, enc.Args(format)...)
+	_, e := cmdToolkit.Run("ffmpeg", append(args, dst)...)
+	if e != nil {
+		return fmt.Errorf("compress %s failed:%w", format, e)
+	}
+	return nil
+}
+
+// DecodeBitstream decodes a raw hevc or obu stream of tiles into one image, cols×rows tiles are cropped to w×h, vf follows
+//
+// ffmpeg -y -f hevc -i a.hevc -vf tile=8x6,crop=4032:3024:0:0,transpose=clock -frames:v 1 -update 1 a.png
+func DecodeBitstream(dst, src, format string, cols, rows, w, h int, vf string) error {
+	filters := []string{}
+	if cols*rows > 1 {
+		filters = append(filters, fmt.Sprintf("tile=%dx%d,crop=%d:%d:0:0", cols, rows, w, h))
+	}
+	if vf != "" {
+		filters = append(filters, vf)
+	}
+	args := []string{"-y", "-f", format, "-i", src}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args, "-frames:v", "1", "-update", "1", dst)
+	output, e := cmdToolkit.Run("ffmpeg", args...)
+	if e != nil {
+		return fmt.Errorf("%w: %s", e, output)
+	}
+	return nil
+}
+
+// JoinAPNG joins images matching pattern, e.g. a.%d.png from 1, into an endless APNG at fps, fitted into the size of the first
+//
+// ffmpeg -y -framerate 1 -i a.%d.png -vf scale=4032:3024:force_original_aspect_ratio=decrease,pad=4032:3024:-1:-1 -plays 0 -f apng a.png
+func JoinAPNG(dst, pattern string, fps, w, h int) error {
+	vf := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:-1:-1", w, h, w, h)
+	output, e := cmdToolkit.Run("ffmpeg", "-y", "-framerate", strconv.Itoa(fps), "-start_number", "1", "-i", pattern, "-vf", vf, "-plays", "0", "-f", "apng", dst)
+	if e != nil {
+		return fmt.Errorf("%w: %s", e, output)
+	}
+	return nil
+}
+
+// DecodeSequence converts the first video track of a file, e.g. an animated AVIF or a HEIF burst, into an endless APNG
+//
+// ffmpeg -y -i a.avif -map 0:v:0 -plays 0 -f apng a.png
+func DecodeSequence(dst, filename string) error {
+	output, e := cmdToolkit.Run("ffmpeg", "-y", "-i", filename, "-map", "0:v:0", "-plays", "0", "-f", "apng", dst)
+	if e != nil {
+		return fmt.Errorf("%w: %s", e, output)
+	}
+	return nil
+}
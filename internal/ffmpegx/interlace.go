@@ -0,0 +1,63 @@
+package ffmpegx
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+const (
+	FIELD_ORDER_PROGRESSIVE = "progressive"
+	FIELD_ORDER_TFF         = "tff" // top field first
+	FIELD_ORDER_BFF         = "bff" // bottom field first
+
+	DEINTERLACE_YADIF = "yadif"
+	DEINTERLACE_BWDIF = "bwdif"
+
+	IDET_SAMPLE_FRAMES = 300
+)
+
+var (
+	// [Parsed_idet_0 @ 0x7f8] Multi frame detection: TFF:   123 BFF:     0 Progressive:    10 Undetermined:    67
+	idetRegexp = regexp.MustCompile(`Multi frame detection: TFF:\s*(\d+)\s*BFF:\s*(\d+)\s*Progressive:\s*(\d+)`)
+)
+
+// DetectFieldOrder runs idet on a sample of frames from start seconds, returns one of the FIELD_ORDER_ constants
+//
+// ffmpeg -ss 10 -i a.mp4 -vf idet -frames:v 300 -an -f null -
+func DetectFieldOrder(filename string, start float64) (string, error) {
+	output, e := cmdToolkit.Run("ffmpeg", "-ss", formatSeconds(start), "-i", filename, "-vf", "idet", "-frames:v", strconv.Itoa(IDET_SAMPLE_FRAMES), "-an", "-f", "null", "-")
+	if e != nil {
+		return "", e
+	}
+	match := idetRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return "", errors.New("no idet result in output")
+	}
+	tff, _ := strconv.Atoi(match[1])
+	bff, _ := strconv.Atoi(match[2])
+	progressive, _ := strconv.Atoi(match[3])
+	switch {
+	case tff+bff <= progressive:
+		return FIELD_ORDER_PROGRESSIVE, nil
+	case tff >= bff:
+		return FIELD_ORDER_TFF, nil
+	default:
+		return FIELD_ORDER_BFF, nil
+	}
+}
+
+func (m *MediaInfo) IsInterlaced() bool {
+	return m.FieldOrder == FIELD_ORDER_TFF || m.FieldOrder == FIELD_ORDER_BFF
+}
+
+// DeinterlaceFilter returns the yadif|bwdif filter for the field order, it must come first in the filter chain
+func DeinterlaceFilter(deinterlacer, fieldOrder string) string {
+	if deinterlacer == "" {
+		deinterlacer = DEINTERLACE_YADIF
+	}
+	return fmt.Sprintf("%s=parity=%s", deinterlacer, fieldOrder)
+}
@@ -0,0 +1,164 @@
+package ffmpegx
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Rect is a crop rectangle in source pixels
+	Rect struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+		W int `json:"w"`
+		H int `json:"h"`
+	}
+
+	// Layout decides how a source is fitted into the output size
+	Layout struct {
+		Mode     string `json:"mode"`     // fit|fill|pad
+		Aspect   string `json:"aspect"`   // 1:1, 4:5, 9:16 ..., empty keeps the source aspect
+		PadColor string `json:"padColor"` // color of the letterbox in pad mode, or `blur`
+		Crop     *Rect  `json:"crop"`     // applied before everything else
+	}
+)
+
+const (
+	LAYOUT_FIT  = "fit"
+	LAYOUT_FILL = "fill"
+	LAYOUT_PAD  = "pad"
+
+	PAD_BLUR = "blur"
+)
+
+var (
+	// named colors or hex ones, nothing that can break out of the filter graph
+	padColorRegex = regexp.MustCompile(`^([a-zA-Z]+|#[0-9a-fA-F]{6}([0-9a-fA-F]{2})?|0x[0-9a-fA-F]{6}([0-9a-fA-F]{2})?)$`)
+)
+
+// ParseRect parses `w:h:x:y`, the same order as ffmpeg's crop filter
+func ParseRect(s string) (*Rect, error) {
+	ss := strings.Split(s, ":")
+	if len(ss) != 4 {
+		return nil, fmt.Errorf("Invalid crop format: %s", s)
+	}
+	nums := make([]int, 4)
+	for i, v := range ss {
+		num, e := strconv.Atoi(strings.TrimSpace(v))
+		if e != nil || num < 0 {
+			return nil, fmt.Errorf("Invalid crop format: %s", s)
+		}
+		nums[i] = num
+	}
+	if nums[0] == 0 || nums[1] == 0 {
+		return nil, fmt.Errorf("Invalid crop size: %s", s)
+	}
+	return &Rect{W: nums[0], H: nums[1], X: nums[2], Y: nums[3]}, nil
+}
+
+func (l *Layout) Validate() error {
+	switch l.Mode {
+	case "", LAYOUT_FIT, LAYOUT_FILL, LAYOUT_PAD:
+	default:
+		return errors.New("unsupported layout mode:" + l.Mode)
+	}
+	if l.Aspect != "" {
+		_, _, e := parseAspect(l.Aspect)
+		if e != nil {
+			return e
+		}
+	}
+	if l.PadColor != "" && !padColorRegex.MatchString(l.PadColor) {
+		return errors.New("invalid pad color:" + l.PadColor)
+	}
+	return nil
+}
+
+// Filter returns the filter graph that fits a w×h source into the widthConstraint×heightConstraint box,
+// along with the output size. Output sizes are always even, so that yuv420p encoders accept them.
+func (l *Layout) Filter(widthConstraint, heightConstraint int, w, h int) (string, int, int) {
+	filters := []string{}
+	if l.Crop != nil {
+		crop := l.Crop.clamp(w, h)
+		filters = append(filters, fmt.Sprintf("crop=%d:%d:%d:%d", crop.W, crop.H, crop.X, crop.Y))
+		w, h = crop.W, crop.H
+	}
+
+	aw, ah, e := parseAspect(l.Aspect)
+	if l.Mode == "" || l.Mode == LAYOUT_FIT || e != nil {
+		ow, oh := FitConstraint(widthConstraint, heightConstraint, w, h)
+		ow, oh = Even(ow), Even(oh)
+		filters = append(filters, fmt.Sprintf("scale=%d:%d", ow, oh))
+		return strings.Join(filters, ","), ow, oh
+	}
+
+	// the largest box of the aspect within the constraint
+	ow, oh := FitConstraint(widthConstraint, heightConstraint, aw*10000, ah*10000)
+	// never upscale: fill needs the box inside the source, pad needs the source inside the box
+	var sw, sh int
+	if l.Mode == LAYOUT_FILL {
+		sw, sh = FitConstraint(w, h, aw*10000, ah*10000)
+	} else if w*ah > h*aw {
+		sw, sh = w, w*ah/aw
+	} else {
+		sw, sh = h*aw/ah, h
+	}
+	if sw < ow {
+		ow, oh = sw, sh
+	}
+	ow, oh = Even(ow), Even(oh)
+
+	switch {
+	case l.Mode == LAYOUT_FILL:
+		filters = append(filters, fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d", ow, oh, ow, oh))
+	case l.PadColor == PAD_BLUR:
+		filters = append(filters, fmt.Sprintf("split[bg0][fg0];[bg0]scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d,boxblur=20:5[bg];[fg0]scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2[fg];[bg][fg]overlay=(W-w)/2:(H-h)/2", ow, oh, ow, oh, ow, oh))
+	default:
+		color := l.PadColor
+		if color == "" {
+			color = "black"
+		}
+		filters = append(filters, fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=%s", ow, oh, ow, oh, color))
+	}
+	return strings.Join(filters, ","), ow, oh
+}
+
+// Even rounds n down to an even number, at least 2
+func Even(n int) int {
+	if n < 2 {
+		return 2
+	}
+	return n &^ 1
+}
+
+func (r *Rect) clamp(w, h int) Rect {
+	out := *r
+	if out.X >= w {
+		out.X = 0
+	}
+	if out.Y >= h {
+		out.Y = 0
+	}
+	if out.X+out.W > w {
+		out.W = w - out.X
+	}
+	if out.Y+out.H > h {
+		out.H = h - out.Y
+	}
+	return out
+}
+
+func parseAspect(s string) (int, int, error) {
+	ss := strings.Split(s, ":")
+	if len(ss) == 2 {
+		a, e1 := strconv.Atoi(strings.TrimSpace(ss[0]))
+		b, e2 := strconv.Atoi(strings.TrimSpace(ss[1]))
+		if e1 == nil && e2 == nil && a > 0 && b > 0 {
+			return a, b, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("Invalid aspect format: %s", s)
+}
@@ -0,0 +1,72 @@
+package ffmpegx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLayoutValidatePadColor(t *testing.T) {
+	cases := []struct {
+		color string
+		ok    bool
+	}{
+		{"", true},
+		{"blur", true},
+		{"black", true},
+		{"WhiteSmoke", true},
+		{"#1a2B3c", true},
+		{"#1a2B3c80", true},
+		{"0x1a2b3c", true},
+		{"0x1a2b3cff", true},
+		{"#fff", false},
+		{"0x1a2b3c8", false},
+		{"black,drawtext=text=x", false},
+		{"black;[0:v]null", false},
+		{"black[x]", false},
+		{"black:t=fill", false},
+	}
+	for _, c := range cases {
+		l := &Layout{Mode: LAYOUT_PAD, Aspect: "1:1", PadColor: c.color}
+		if e := l.Validate(); (e == nil) != c.ok {
+			t.Errorf("%q: got %v, want ok %v", c.color, e, c.ok)
+		}
+	}
+}
+
+func TestLayoutFilter(t *testing.T) {
+	cases := []struct {
+		name   string
+		layout Layout
+		w, h   int
+		want   string
+		ow, oh int
+	}{
+		{"fit", Layout{}, 1920, 1080, "scale=1280:720", 1280, 720},
+		{"fit never upscales", Layout{}, 640, 360, "scale=640:360", 640, 360},
+		{"fill", Layout{Mode: LAYOUT_FILL, Aspect: "1:1"}, 1920, 1080, "scale=1080:1080:force_original_aspect_ratio=increase,crop=1080:1080", 1080, 1080},
+		{"pad", Layout{Mode: LAYOUT_PAD, Aspect: "1:1", PadColor: "#102030"}, 1920, 1080, "scale=1280:1280:force_original_aspect_ratio=decrease:force_divisible_by=2,pad=1280:1280:(ow-iw)/2:(oh-ih)/2:color=#102030", 1280, 1280},
+		{"crop first", Layout{Crop: &Rect{X: 100, Y: 0, W: 800, H: 600}}, 1920, 1080, "crop=800:600:100:0,scale=800:600", 800, 600},
+	}
+	for _, c := range cases {
+		vf, ow, oh := c.layout.Filter(1280, 1280, c.w, c.h)
+		if vf != c.want || ow != c.ow || oh != c.oh {
+			t.Errorf("%s: got %s %dx%d, want %s %dx%d", c.name, vf, ow, oh, c.want, c.ow, c.oh)
+		}
+	}
+}
+
+func TestInputLabels(t *testing.T) {
+	l := &Layout{Mode: LAYOUT_PAD, Aspect: "9:16", PadColor: PAD_BLUR}
+	vf, _, _ := l.Filter(720, 1280, 1920, 1080)
+	args := ConcatFilterArgs([]Segment{{Duration: 1}, {Duration: 1}}, []string{vf, vf})
+	graph := args[1]
+	for _, label := range []string{"[bg0_0]", "[fg0_0]", "[bg_0]", "[fg_0]", "[bg0_1]", "[fg0_1]", "[bg_1]", "[fg_1]"} {
+		if strings.Count(graph, label) != 2 {
+			t.Errorf("%s should link exactly once in %s", label, graph)
+		}
+	}
+	// labels of the graph itself are kept
+	if !strings.HasSuffix(graph, "concat=n=2:v=1:a=1[v][a]") {
+		t.Errorf("concat outputs changed in %s", graph)
+	}
+}
@@ -0,0 +1,97 @@
+package ffmpegx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+// PreviewOptions makes a short silent looping preview of a video, for grid views
+type PreviewOptions struct {
+	Mode     string  `json:"mode"`     // opening|montage, opening by default
+	Duration float64 `json:"duration"` // seconds, 4 by default, within 3-6
+	Snippets int     `json:"snippets"` // snippets spread across the video in montage mode, 4 by default
+	Width    int     `json:"width"`    // 320 by default, never upscaled
+}
+
+const (
+	PREVIEW_OPENING = "opening"
+	PREVIEW_MONTAGE = "montage"
+
+	PREVIEW_FPS = 15
+)
+
+func (o PreviewOptions) WithDefaults() PreviewOptions {
+	if o.Mode != PREVIEW_MONTAGE {
+		o.Mode = PREVIEW_OPENING
+	}
+	if o.Duration <= 0 {
+		o.Duration = 4
+	}
+	o.Duration = math.Max(3, math.Min(6, o.Duration))
+	if o.Snippets <= 0 {
+		o.Snippets = 4
+	}
+	if o.Width <= 0 {
+		o.Width = 320
+	}
+	return o
+}
+
+func (o PreviewOptions) Validate() error {
+	switch o.Mode {
+	case "", PREVIEW_OPENING, PREVIEW_MONTAGE:
+	default:
+		return errors.New("unsupported preview mode:" + o.Mode)
+	}
+	if o.Duration < 0 || o.Snippets < 0 || o.Width < 0 {
+		return errors.New("preview duration, snippets and width can't be negative")
+	}
+	return nil
+}
+
+// SnippetOffsets returns the start seconds of each snippet in a media lasting duration seconds, and the length of each snippet
+func (o *PreviewOptions) SnippetOffsets(duration int) ([]float64, float64) {
+	total := float64(duration)
+	if o.Mode != PREVIEW_MONTAGE || total <= o.Duration || o.Snippets < 2 {
+		return []float64{0}, o.Duration
+	}
+	length := o.Duration / float64(o.Snippets)
+	offsets := []float64{}
+	for i := 0; i < o.Snippets; i++ {
+		// centered in each of the equal parts
+		offset := (float64(i)+0.5)*total/float64(o.Snippets) - length/2
+		offsets = append(offsets, math.Max(0, offset))
+	}
+	return offsets, length
+}
+
+// CreatePreviewMP4 joins the snippets at starts, each lasting length seconds, into a silent MP4
+//
+// ffmpeg -ss 10 -t 1 -i a.mp4 -ss 40 -t 1 -i a.mp4 -filter_complex [0:v]scale=320:180,fps=15,setsar=1[v0];[1:v]...;[v0][v1]concat=n=2:v=1:a=0[v] -map [v] -an preview.mp4
+func CreatePreviewMP4(dst, filename string, starts []float64, length float64, vf string) error {
+	args := []string{"-y"}
+	b := new(strings.Builder)
+	for i, start := range starts {
+		args = append(args, "-ss", formatSeconds(start), "-t", formatSeconds(length), "-i", filename)
+		fmt.Fprintf(b, "[%d:v]%s,fps=%d,format=yuv420p,setsar=1[v%d];", i, inputLabels(vf, i), PREVIEW_FPS, i)
+	}
+	for i := range starts {
+		fmt.Fprintf(b, "[v%d]", i)
+	}
+	fmt.Fprintf(b, "concat=n=%d:v=1:a=0[v]", len(starts))
+	args = append(args, "-filter_complex", b.String(), "-map", "[v]", "-an", "-c:v", "libx264", "-crf", "30", "-movflags", "+faststart", dst)
+	_, e := cmdToolkit.Run("ffmpeg", args...)
+	return e
+}
+
+// CreatePreviewWebP converts the MP4 preview into an infinitely looping animated WebP
+//
+// ffmpeg -i preview.mp4 -c:v libwebp_anim -q:v 60 -loop 0 preview.webp
+func CreatePreviewWebP(dst, mp4 string) error {
+	_, e := cmdToolkit.Run("ffmpeg", "-y", "-i", mp4, "-c:v", "libwebp_anim", "-q:v", "60", "-loop", "0", dst)
+	return e
+}
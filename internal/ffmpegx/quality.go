@@ -0,0 +1,170 @@
+package ffmpegx
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+// Score summarizes the per-frame scores of a metric
+type Score struct {
+	Mean float64 `json:"mean"`
+	Min  float64 `json:"min"`
+	P5   float64 `json:"p5"`  // 5% of frames score lower
+	P10  float64 `json:"p10"` // 10% of frames score lower
+}
+
+// QualityReport is the quality of an output against its source, VMAF if ffmpeg has libvmaf, otherwise SSIM and PSNR
+type QualityReport struct {
+	VMAF *Score `json:"vmaf,omitempty"` // 0-100
+	SSIM *Score `json:"ssim,omitempty"` // 0-1
+	PSNR *Score `json:"psnr,omitempty"` // dB, identical frames are capped at MAX_PSNR
+}
+
+const (
+	MAX_PSNR = 100
+)
+
+var (
+	ssimRegex = regexp.MustCompile(`All:([\d.]+)`)
+	psnrRegex = regexp.MustCompile(`psnr_avg:([\d.]+|inf)`)
+)
+
+// MeasureQuality compares an encoded output with its source, read with input which includes `-i`, and laid out by vf the same way.
+// Both are converted to yuv420p, HDR outputs are compared in 8 bits
+//
+// ffmpeg -i a.av1.mp4 -ss 10 -t 20 -i a.mp4 -lavfi [0:v]format=yuv420p,setpts=PTS-STARTPTS[d];[1:v]scale=1280:720,fps=10,format=yuv420p,setpts=PTS-STARTPTS[r];[d][r]libvmaf=log_fmt=json:log_path=a.vmaf.json -f null -
+func MeasureQuality(dst string, input []string, vf string) (*QualityReport, error) {
+	args := append([]string{"-i", dst}, input...)
+	graph := fmt.Sprintf("[0:v]format=yuv420p,setpts=PTS-STARTPTS[d];[1:v]%s,fps=%d,format=yuv420p,setpts=PTS-STARTPTS[r];", vf, OUTPUT_FPS)
+
+	if HasFilter("libvmaf") {
+		log := dst + ".vmaf.json"
+		defer os.Remove(log)
+		graph += "[d][r]libvmaf=log_fmt=json:log_path=" + log
+		output, e := cmdToolkit.Run("ffmpeg", append(args, "-lavfi", graph, "-f", "null", "-")...)
+		if e != nil {
+			return nil, fmt.Errorf("%w: %s", e, output)
+		}
+		scores, e := readVMAFLog(log)
+		if e != nil {
+			return nil, e
+		}
+		return &QualityReport{VMAF: summarize(scores)}, nil
+	}
+
+	ssimLog, psnrLog := dst+".ssim.log", dst+".psnr.log"
+	defer os.Remove(ssimLog)
+	defer os.Remove(psnrLog)
+	graph += "[d]split[d1][d2];[r]split[r1][r2];[d1][r1]ssim=stats_file=" + ssimLog + ";[d2][r2]psnr=stats_file=" + psnrLog
+	output, e := cmdToolkit.Run("ffmpeg", append(args, "-lavfi", graph, "-f", "null", "-")...)
+	if e != nil {
+		return nil, fmt.Errorf("%w: %s", e, output)
+	}
+	ssim, e := readStatsFile(ssimLog, ssimRegex)
+	if e != nil {
+		return nil, e
+	}
+	psnr, e := readStatsFile(psnrLog, psnrRegex)
+	if e != nil {
+		return nil, e
+	}
+	return &QualityReport{SSIM: summarize(ssim), PSNR: summarize(psnr)}, nil
+}
+
+// readVMAFLog reads per-frame scores of a libvmaf JSON log
+func readVMAFLog(filename string) ([]float64, error) {
+	b, e := os.ReadFile(filename)
+	if e != nil {
+		return nil, e
+	}
+	var log struct {
+		Frames []struct {
+			Metrics struct {
+				VMAF float64 `json:"vmaf"`
+			} `json:"metrics"`
+		} `json:"frames"`
+	}
+	e = json.Unmarshal(b, &log)
+	if e != nil {
+		return nil, fmt.Errorf("parse %s failed:%w", filename, e)
+	}
+	scores := []float64{}
+	for _, frame := range log.Frames {
+		scores = append(scores, frame.Metrics.VMAF)
+	}
+	return scores, nil
+}
+
+// readStatsFile reads per-frame scores of a ssim or psnr stats file, one line a frame
+func readStatsFile(filename string, regex *regexp.Regexp) ([]float64, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	scores := []float64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := regex.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		if m[1] == "inf" {
+			scores = append(scores, MAX_PSNR)
+			continue
+		}
+		v, e := strconv.ParseFloat(m[1], 64)
+		if e != nil {
+			return nil, e
+		}
+		scores = append(scores, math.Min(v, MAX_PSNR))
+	}
+	return scores, scanner.Err()
+}
+
+// summarize returns the mean, min and low percentiles of scores, nil if there's none
+func summarize(scores []float64) *Score {
+	if len(scores) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), scores...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	percentile := func(p float64) float64 {
+		return round3(sorted[int(p*float64(len(sorted)-1))])
+	}
+	return &Score{
+		Mean: round3(sum / float64(len(sorted))),
+		Min:  round3(sorted[0]),
+		P5:   percentile(0.05),
+		P10:  percentile(0.10),
+	}
+}
+
+func round3(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+// String is e.g. "vmaf 93.1 (min 80.2)", for logs
+func (r *QualityReport) String() string {
+	parts := []string{}
+	for name, s := range map[string]*Score{"vmaf": r.VMAF, "ssim": r.SSIM, "psnr": r.PSNR} {
+		if s != nil {
+			parts = append(parts, fmt.Sprintf("%s %g (min %g)", name, s.Mean, s.Min))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,63 @@
+package ffmpegx
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DecodeRGBA decodes the first frame of filename, laid out by vf if any, scaled to w×h in raw RGBA
+//
+// ffmpeg -v error -noautorotate -i a.avif -vf scale=100:56 -frames:v 1 -f rawvideo -pix_fmt rgba -
+func DecodeRGBA(filename, vf string, w, h int) ([]byte, error) {
+	return decodeRaw([]string{"-noautorotate", "-i", filename}, scaleAfter(vf, w, h), "rgba", 1, w*h*4)
+}
+
+// DecodeGray decodes the first frame of filename, laid out by vf if any, scaled to w×h in 8-bit gray
+func DecodeGray(filename, vf string, w, h int) ([]byte, error) {
+	return decodeRaw([]string{"-noautorotate", "-i", filename}, scaleAfter(vf, w, h), "gray", 1, w*h)
+}
+
+// DecodeGrayFrames decodes the frames of a video at each of offsets seconds, scaled to w×h in 8-bit gray and concatenated
+//
+// # Each frame is sought on its own, which is much faster than decoding the whole video through the fps filter
+//
+// ffmpeg -v error -ss 12.5 -i a.mp4 -vf scale=32:32 -frames:v 1 -f rawvideo -pix_fmt gray -
+func DecodeGrayFrames(filename string, offsets []float64, w, h int) ([]byte, error) {
+	out := []byte{}
+	for _, at := range offsets {
+		frame, e := decodeRaw([]string{"-ss", formatSeconds(at), "-i", filename}, scaleAfter("", w, h), "gray", 1, w*h)
+		if e != nil {
+			return nil, e
+		}
+		out = append(out, frame...)
+	}
+	return out, nil
+}
+
+func scaleAfter(vf string, w, h int) string {
+	scale := fmt.Sprintf("scale=%d:%d", w, h)
+	if vf != "" {
+		scale = vf + "," + scale
+	}
+	return scale
+}
+
+// decodeRaw runs ffmpeg with raw frames on stdout, size is the expected number of bytes, 0 skips the check
+func decodeRaw(input []string, vf, pixFmt string, frames, size int) ([]byte, error) {
+	args := append([]string{"-v", "error"}, input...)
+	args = append(args, "-vf", vf, "-frames:v", fmt.Sprint(frames), "-f", "rawvideo", "-pix_fmt", pixFmt, "-")
+	// stdout carries the pixels, so it can't be mixed with stderr like cmdToolkit.Run does
+	cmd := exec.Command("ffmpeg", args...)
+	stderr := new(strings.Builder)
+	cmd.Stderr = stderr
+	out, e := cmd.Output()
+	filename := input[len(input)-1]
+	if e != nil {
+		return nil, fmt.Errorf("decode %s: %w: %s", filename, e, stderr.String())
+	}
+	if size > 0 && len(out) != size {
+		return nil, fmt.Errorf("decode %s: got %d bytes of %s, expected %d", filename, len(out), pixFmt, size)
+	}
+	return out, nil
+}
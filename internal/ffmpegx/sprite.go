@@ -0,0 +1,105 @@
+package ffmpegx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+// SpriteOptions tiles one thumbnail every Interval seconds into sprite sheets, for seek-bar previews
+type SpriteOptions struct {
+	Interval float64 `json:"interval"` // seconds, 10 by default
+	Width    int     `json:"width"`    // thumbnail width, 160 by default
+	Columns  int     `json:"columns"`  // 10 by default
+	Rows     int     `json:"rows"`     // 10 by default
+	Format   string  `json:"format"`   // avif|webp|jpg, jpg by default
+}
+
+var (
+	spriteQualityArgs = map[string][]string{
+		"avif": {"-crf", "40", "-b:v", "0"},
+		"webp": {"-q:v", "75"},
+		"jpg":  {"-q:v", "5"},
+	}
+)
+
+func (o SpriteOptions) WithDefaults() SpriteOptions {
+	if o.Interval <= 0 {
+		o.Interval = 10
+	}
+	if o.Width <= 0 {
+		o.Width = 160
+	}
+	if o.Columns <= 0 {
+		o.Columns = 10
+	}
+	if o.Rows <= 0 {
+		o.Rows = 10
+	}
+	if _, ok := spriteQualityArgs[o.Format]; !ok {
+		o.Format = "jpg"
+	}
+	return o
+}
+
+func (o SpriteOptions) Validate() error {
+	if o.Format != "" {
+		if _, ok := spriteQualityArgs[o.Format]; !ok {
+			return errors.New("unsupported sprite format:" + o.Format)
+		}
+	}
+	if o.Interval < 0 || o.Width < 0 || o.Columns < 0 || o.Rows < 0 {
+		return errors.New("sprite interval, width, columns and rows can't be negative")
+	}
+	return nil
+}
+
+// Count returns the number of thumbnails and sheets covering duration seconds
+func (o *SpriteOptions) Count(duration int) (int, int) {
+	cues := int(math.Ceil(float64(duration) / o.Interval))
+	if cues < 1 {
+		cues = 1
+	}
+	perSheet := o.Columns * o.Rows
+	return cues, (cues + perSheet - 1) / perSheet
+}
+
+// CreateSpriteSheet tiles the thumbnails of [start, start+length) into one sheet, vf lays the video out
+//
+// ffmpeg -ss 0 -t 1000 -i a.mp4 -vf fps=1/10,scale=160:90,tile=10x10 -frames:v 1 sprite.0.jpg
+func (o *SpriteOptions) CreateSpriteSheet(dst, filename string, start, length float64, vf string, tw, th int) error {
+	args := []string{"-y", "-ss", formatSeconds(start), "-t", formatSeconds(length), "-i", filename,
+		"-vf", fmt.Sprintf("%s,fps=1/%s,scale=%d:%d,tile=%dx%d", vf, formatSeconds(o.Interval), tw, th, o.Columns, o.Rows),
+		"-frames:v", "1"}
+	args = append(args, spriteQualityArgs[o.Format]...)
+	_, e := cmdToolkit.Run("ffmpeg", append(args, dst)...)
+	return e
+}
+
+// WriteSpriteVTT maps every Interval of duration seconds to its region in sheets, which are URLs relative to the VTT file
+//
+// 00:00:10.000 --> 00:00:20.000
+// sprite.0.jpg#xywh=160,0,160,90
+func (o *SpriteOptions) WriteSpriteVTT(dst string, sheets []string, duration int, tw, th int) error {
+	b := new(strings.Builder)
+	b.WriteString("WEBVTT\n")
+	cues, _ := o.Count(duration)
+	perSheet := o.Columns * o.Rows
+	for i := 0; i < cues; i++ {
+		start := float64(i) * o.Interval
+		end := math.Min(start+o.Interval, float64(duration))
+		idx := i % perSheet
+		fmt.Fprintf(b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", formatVTTTime(start), formatVTTTime(end), sheets[i/perSheet], idx%o.Columns*tw, idx/o.Columns*th, tw, th)
+	}
+	return os.WriteFile(dst, []byte(b.String()), 0644)
+}
+
+// formatVTTTime formats seconds as `00:01:20.500`
+func formatVTTTime(f float64) string {
+	ms := int64(math.Round(f * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -24,3 +24,17 @@ func NotFound(c *gin.Context, args ...any) {
 		"message": fmt.Sprint(args...),
 	})
 }
+
+func UnsupportedMediaType(c *gin.Context, args ...any) {
+	c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{
+		"code":    415,
+		"message": fmt.Sprint(args...),
+	})
+}
+
+func UnprocessableEntity(c *gin.Context, args ...any) {
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+		"code":    422,
+		"message": fmt.Sprint(args...),
+	})
+}
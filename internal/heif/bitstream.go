@@ -0,0 +1,129 @@
+package heif
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	startCode         = []byte{0, 0, 0, 1}
+	temporalDelimiter = []byte{0x12, 0x00}
+)
+
+// Grid returns the tiles of a grid image in row-major order, with the numbers of columns and rows and the output size
+func (f *File) Grid(it *Item) ([]*Item, int, int, int, int, error) {
+	b, e := f.Data(it)
+	if e != nil {
+		return nil, 0, 0, 0, 0, e
+	}
+	r := &reader{b: b}
+	r.u8() // version
+	flags := r.u8()
+	rows := int(r.u8()) + 1
+	cols := int(r.u8()) + 1
+	var w, h int
+	if flags&1 == 1 {
+		w, h = int(r.u32()), int(r.u32())
+	} else {
+		w, h = int(r.u16()), int(r.u16())
+	}
+	if r.e != nil {
+		return nil, 0, 0, 0, 0, r.e
+	}
+	ids := it.Refs["dimg"]
+	if len(ids) != rows*cols {
+		return nil, 0, 0, 0, 0, fmt.Errorf("%w: grid %d has %d tiles for %dx%d", ErrInvalid, it.ID, len(ids), cols, rows)
+	}
+	tiles := []*Item{}
+	for _, id := range ids {
+		tile, ok := f.Items[id]
+		if !ok || !tile.IsImage() || tile.Type == "grid" {
+			return nil, 0, 0, 0, 0, fmt.Errorf("%w: invalid tile %d of grid %d", ErrInvalid, id, it.ID)
+		}
+		tiles = append(tiles, tile)
+	}
+	return tiles, cols, rows, w, h, nil
+}
+
+// Bitstream joins coded images of the same codec into a raw stream of one frame each,
+// returning the ffmpeg format to read it with, hevc or obu
+func (f *File) Bitstream(items []*Item) ([]byte, string, error) {
+	out := []byte{}
+	format := ""
+	for _, it := range items {
+		data, e := f.Data(it)
+		if e != nil {
+			return nil, "", e
+		}
+		switch it.Type {
+		case "hvc1":
+			format = "hevc"
+			out, e = appendHEVC(out, it.Property("hvcC"), data)
+		case "av01":
+			format = "obu"
+			out, e = appendAV1(out, it.Property("av1C"), data)
+		default:
+			e = fmt.Errorf("%w: unsupported image type %s of item %d", ErrInvalid, it.Type, it.ID)
+		}
+		if e != nil {
+			return nil, "", e
+		}
+	}
+	return out, format, nil
+}
+
+// appendHEVC appends the parameter sets of hvcC and the length-prefixed NAL units of data, in Annex B
+func appendHEVC(out, hvcC, data []byte) ([]byte, error) {
+	if len(hvcC) < 23 {
+		return nil, fmt.Errorf("%w: missing hvcC", ErrInvalid)
+	}
+	lengthSize := int(hvcC[21]&3) + 1
+	r := &reader{b: hvcC, i: 22}
+	arrays := int(r.u8())
+	for i := 0; i < arrays && r.e == nil; i++ {
+		r.u8() // NAL unit type
+		n := int(r.u16())
+		for j := 0; j < n && r.e == nil; j++ {
+			size := int(r.u16())
+			out = append(out, startCode...)
+			out = append(out, r.bytes(size)...)
+		}
+	}
+	if r.e != nil {
+		return nil, r.e
+	}
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nil, fmt.Errorf("%w: truncated NAL unit", ErrInvalid)
+		}
+		size := 0
+		for _, c := range data[:lengthSize] {
+			size = size<<8 | int(c)
+		}
+		data = data[lengthSize:]
+		if size > len(data) {
+			return nil, fmt.Errorf("%w: truncated NAL unit", ErrInvalid)
+		}
+		out = append(out, startCode...)
+		out = append(out, data[:size]...)
+		data = data[size:]
+	}
+	return out, nil
+}
+
+// appendAV1 appends a temporal unit of the sequence header of av1C and the OBUs of data
+func appendAV1(out, av1C, data []byte) ([]byte, error) {
+	if len(av1C) < 4 {
+		return nil, fmt.Errorf("%w: missing av1C", ErrInvalid)
+	}
+	out = append(out, temporalDelimiter...)
+	out = append(out, av1C[4:]...)
+	// a temporal delimiter inside the item would start another frame
+	if len(data) >= 2 && data[0]>>3&15 == 2 && data[0]&2 != 0 {
+		size, n := binary.Uvarint(data[1:])
+		if n > 0 && 1+n+int(size) <= len(data) {
+			data = data[1+n+int(size):]
+		}
+	}
+	return append(out, data...), nil
+}
@@ -0,0 +1,75 @@
+package heif
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// walk calls fn with the type and body of every box in b
+func walk(b []byte, fn func(typ string, body []byte) error) error {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			// to the end
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return fmt.Errorf("%w: short box %s", ErrInvalid, typ)
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return fmt.Errorf("%w: box %s out of range", ErrInvalid, typ)
+		}
+		e := fn(typ, b[header:size])
+		if e != nil {
+			return e
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// reader reads big-endian fields, the first overrun is kept in e and later reads return zeros
+type reader struct {
+	b []byte
+	i int
+	e error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.e != nil || r.i+n > len(r.b) {
+		if r.e == nil {
+			r.e = fmt.Errorf("%w: short box", ErrInvalid)
+		}
+		return make([]byte, n)
+	}
+	out := r.b[r.i : r.i+n]
+	r.i += n
+	return out
+}
+
+func (r *reader) skip(n int)  { r.bytes(n) }
+func (r *reader) u8() uint8   { return r.bytes(1)[0] }
+func (r *reader) u16() uint16 { return binary.BigEndian.Uint16(r.bytes(2)) }
+func (r *reader) u32() uint32 { return binary.BigEndian.Uint32(r.bytes(4)) }
+func (r *reader) u24() uint32 {
+	b := r.bytes(3)
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+func (r *reader) uint(n int) uint64 {
+	switch n {
+	case 0:
+		return 0
+	case 4:
+		return uint64(r.u32())
+	case 8:
+		return binary.BigEndian.Uint64(r.bytes(8))
+	}
+	r.e = fmt.Errorf("%w: unsupported field size %d", ErrInvalid, n)
+	return 0
+}
@@ -0,0 +1,435 @@
+package heif
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// File is the item structure of a HEIF/AVIF file, from its meta box
+type File struct {
+	Primary     uint32
+	Items       map[uint32]*Item
+	HasSequence bool // there's a moov box, e.g. a burst or an animated AVIF
+
+	b    []byte
+	idat []byte
+}
+
+// Item is an image or metadata item
+type Item struct {
+	ID    uint32
+	Type  string              // hvc1|av01|grid|Exif|mime...
+	Props []Property          // associated properties, in ipma order
+	Refs  map[string][]uint32 // references from this item by type, e.g. dimg => tiles of a grid
+
+	construction uint16 // 0: file offsets, 1: idat offsets
+	baseOffset   uint64
+	extents      [][2]uint64 // offset, length
+}
+
+// Property is a box of ipco, e.g. ispe, irot, imir, hvcC
+type Property struct {
+	Type string
+	Data []byte
+}
+
+var (
+	ErrInvalid = errors.New("invalid HEIF")
+)
+
+// ReadFile parses the meta box of a HEIF or AVIF file
+func ReadFile(filename string) (*File, error) {
+	b, e := os.ReadFile(filename)
+	if e != nil {
+		return nil, e
+	}
+	return Parse(b)
+}
+
+// Parse parses the meta box of HEIF or AVIF content
+func Parse(b []byte) (*File, error) {
+	f := &File{Items: map[uint32]*Item{}, b: b}
+	var meta []byte
+	e := walk(b, func(typ string, body []byte) error {
+		switch typ {
+		case "meta":
+			meta = body
+		case "moov":
+			f.HasSequence = true
+		}
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	if len(meta) < 4 {
+		if f.HasSequence {
+			return f, nil
+		}
+		return nil, fmt.Errorf("%w: no meta box", ErrInvalid)
+	}
+
+	var ipco [][]byte
+	var ipma []byte
+	// meta is a full box
+	e = walk(meta[4:], func(typ string, body []byte) error {
+		switch typ {
+		case "pitm":
+			r := &reader{b: body}
+			if r.u8() == 0 {
+				r.skip(3)
+				f.Primary = uint32(r.u16())
+			} else {
+				r.skip(3)
+				f.Primary = r.u32()
+			}
+			return r.e
+		case "iinf":
+			return f.parseIINF(body)
+		case "iloc":
+			return f.parseILOC(body)
+		case "iref":
+			return f.parseIREF(body)
+		case "idat":
+			f.idat = body
+		case "iprp":
+			return walk(body, func(typ string, body []byte) error {
+				switch typ {
+				case "ipco":
+					return walk(body, func(typ string, body []byte) error {
+						ipco = append(ipco, append([]byte(typ), body...))
+						return nil
+					})
+				case "ipma":
+					ipma = body
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	if ipma != nil {
+		e = f.parseIPMA(ipma, ipco)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return f, nil
+}
+
+func (f *File) item(id uint32) *Item {
+	it, ok := f.Items[id]
+	if !ok {
+		it = &Item{ID: id, Refs: map[string][]uint32{}}
+		f.Items[id] = it
+	}
+	return it
+}
+
+func (f *File) parseIINF(body []byte) error {
+	r := &reader{b: body}
+	version := r.u8()
+	r.skip(3)
+	if version == 0 {
+		r.u16()
+	} else {
+		r.u32()
+	}
+	if r.e != nil {
+		return r.e
+	}
+	return walk(body[r.i:], func(typ string, body []byte) error {
+		if typ != "infe" {
+			return nil
+		}
+		r := &reader{b: body}
+		version := r.u8()
+		r.skip(3)
+		// versions 0 and 1 carry no item type
+		if version < 2 {
+			return r.e
+		}
+		var id uint32
+		if version == 2 {
+			id = uint32(r.u16())
+		} else {
+			id = r.u32()
+		}
+		r.u16() // protection index
+		itemType := string(r.bytes(4))
+		if r.e != nil {
+			return r.e
+		}
+		f.item(id).Type = itemType
+		return nil
+	})
+}
+
+func (f *File) parseILOC(body []byte) error {
+	r := &reader{b: body}
+	version := r.u8()
+	r.skip(3)
+	sizes := r.u16()
+	offsetSize, lengthSize, baseOffsetSize, indexSize := int(sizes>>12), int(sizes>>8&15), int(sizes>>4&15), 0
+	if version >= 1 {
+		indexSize = int(sizes & 15)
+	}
+	var count uint32
+	if version < 2 {
+		count = uint32(r.u16())
+	} else {
+		count = r.u32()
+	}
+	for i := uint32(0); i < count && r.e == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.u16())
+		} else {
+			id = r.u32()
+		}
+		it := f.item(id)
+		if version >= 1 {
+			it.construction = r.u16() & 15
+		}
+		r.u16() // data reference index
+		it.baseOffset = r.uint(baseOffsetSize)
+		extents := int(r.u16())
+		it.extents = nil
+		for j := 0; j < extents && r.e == nil; j++ {
+			r.uint(indexSize)
+			offset := r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			it.extents = append(it.extents, [2]uint64{offset, length})
+		}
+	}
+	return r.e
+}
+
+func (f *File) parseIREF(body []byte) error {
+	r := &reader{b: body}
+	version := r.u8()
+	r.skip(3)
+	if r.e != nil {
+		return r.e
+	}
+	return walk(body[4:], func(typ string, body []byte) error {
+		r := &reader{b: body}
+		id := func() uint32 {
+			if version == 0 {
+				return uint32(r.u16())
+			}
+			return r.u32()
+		}
+		from := id()
+		count := int(r.u16())
+		to := []uint32{}
+		for i := 0; i < count && r.e == nil; i++ {
+			to = append(to, id())
+		}
+		if r.e != nil {
+			return r.e
+		}
+		it := f.item(from)
+		it.Refs[typ] = append(it.Refs[typ], to...)
+		return nil
+	})
+}
+
+func (f *File) parseIPMA(body []byte, ipco [][]byte) error {
+	r := &reader{b: body}
+	version := r.u8()
+	flags := r.u24()
+	count := r.u32()
+	for i := uint32(0); i < count && r.e == nil; i++ {
+		var id uint32
+		if version < 1 {
+			id = uint32(r.u16())
+		} else {
+			id = r.u32()
+		}
+		it := f.item(id)
+		n := int(r.u8())
+		for j := 0; j < n && r.e == nil; j++ {
+			var index int
+			if flags&1 == 1 {
+				index = int(r.u16() & 0x7FFF)
+			} else {
+				index = int(r.u8() & 0x7F)
+			}
+			// 0 means no property, the rest are 1-based
+			if index == 0 || index > len(ipco) {
+				continue
+			}
+			p := ipco[index-1]
+			it.Props = append(it.Props, Property{Type: string(p[:4]), Data: p[4:]})
+		}
+	}
+	return r.e
+}
+
+// Data returns the content of an item, joining its extents
+func (f *File) Data(it *Item) ([]byte, error) {
+	src := f.b
+	switch it.construction {
+	case 0:
+	case 1:
+		src = f.idat
+	default:
+		return nil, fmt.Errorf("%w: unsupported construction method %d of item %d", ErrInvalid, it.construction, it.ID)
+	}
+	out := []byte{}
+	for _, ext := range it.extents {
+		start := it.baseOffset + ext[0]
+		end := uint64(len(src))
+		if ext[1] > 0 {
+			end = start + ext[1]
+		}
+		if start > end || end > uint64(len(src)) {
+			return nil, fmt.Errorf("%w: extent of item %d out of range", ErrInvalid, it.ID)
+		}
+		out = append(out, src[start:end]...)
+	}
+	return out, nil
+}
+
+// Property returns the first associated property of the type, nil if none
+func (it *Item) Property(typ string) []byte {
+	for _, p := range it.Props {
+		if p.Type == typ {
+			return p.Data
+		}
+	}
+	return nil
+}
+
+// IsImage reports whether the item is a coded or grid image
+func (it *Item) IsImage() bool {
+	switch it.Type {
+	case "hvc1", "av01", "grid":
+		return true
+	}
+	return false
+}
+
+// Images returns the top level images, the primary first and the rest by ID,
+// tiles, thumbnails and auxiliary images like depth maps and alpha planes are left out
+func (f *File) Images() []*Item {
+	hidden := map[uint32]bool{}
+	for _, it := range f.Items {
+		for _, tile := range it.Refs["dimg"] {
+			hidden[tile] = true
+		}
+		// thumbnails and auxiliary images refer to their master
+		if len(it.Refs["thmb"]) > 0 || len(it.Refs["auxl"]) > 0 {
+			hidden[it.ID] = true
+		}
+	}
+	images := []*Item{}
+	for _, it := range f.Items {
+		if it.IsImage() && !hidden[it.ID] {
+			images = append(images, it)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].ID == f.Primary || images[j].ID == f.Primary {
+			return images[i].ID == f.Primary
+		}
+		return images[i].ID < images[j].ID
+	})
+	return images
+}
+
+// Exif returns the TIFF content of the Exif item describing the primary image, nil if none
+func (f *File) Exif() ([]byte, error) {
+	var found *Item
+	for _, it := range f.Items {
+		if it.Type != "Exif" {
+			continue
+		}
+		if found == nil {
+			found = it
+		}
+		for _, id := range it.Refs["cdsc"] {
+			if id == f.Primary {
+				found = it
+			}
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	b, e := f.Data(found)
+	if e != nil {
+		return nil, e
+	}
+	// the TIFF header follows an offset, which is usually past "Exif\0\0"
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: short Exif item", ErrInvalid)
+	}
+	offset := 4 + int(binary.BigEndian.Uint32(b))
+	if offset > len(b) {
+		return nil, fmt.Errorf("%w: Exif offset out of range", ErrInvalid)
+	}
+	return b[offset:], nil
+}
+
+// HasICC reports whether the image carries an ICC profile
+func (it *Item) HasICC() bool {
+	for _, p := range it.Props {
+		if p.Type == "colr" && len(p.Data) >= 4 && (string(p.Data[:4]) == "prof" || string(p.Data[:4]) == "rICC") {
+			return true
+		}
+	}
+	return false
+}
+
+// Transform returns the ffmpeg filters applying the irot and imir properties, in their order, empty if none
+func (it *Item) Transform() string {
+	vf := ""
+	add := func(filter string) {
+		if vf != "" {
+			vf += ","
+		}
+		vf += filter
+	}
+	for _, p := range it.Props {
+		if len(p.Data) < 1 {
+			continue
+		}
+		switch p.Type {
+		case "irot":
+			// anti-clockwise, in 90 degrees
+			switch p.Data[0] & 3 {
+			case 1:
+				add("transpose=cclock")
+			case 2:
+				add("hflip,vflip")
+			case 3:
+				add("transpose=clock")
+			}
+		case "imir":
+			if p.Data[0]&1 == 0 {
+				// vertical axis
+				add("hflip")
+			} else {
+				add("vflip")
+			}
+		}
+	}
+	return vf
+}
+
+// Size returns the ispe size of the image
+func (it *Item) Size() (int, int) {
+	p := it.Property("ispe")
+	if len(p) < 12 {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint32(p[4:])), int(binary.BigEndian.Uint32(p[8:]))
+}
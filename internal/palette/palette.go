@@ -0,0 +1,152 @@
+package palette
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Color is one dominant color of an image
+type Color struct {
+	Hex   string  `json:"hex"`   // #rrggbb
+	Ratio float64 `json:"ratio"` // share of the image's opaque pixels, 0-1
+	Text  string  `json:"text"`  // #ffffff or #000000, whichever reads better on top of it
+}
+
+const (
+	KMEANS_ITERATIONS = 10
+	MIN_ALPHA         = 128 // more transparent pixels are ignored
+)
+
+// Extract returns the n most dominant colors of RGBA pixels, most populated first
+//
+// Clusters are seeded by median cut and refined by k-means, so ratios reflect how much of the image each color covers
+func Extract(rgba []byte, n int) []Color {
+	pixels := [][3]float64{}
+	for i := 0; i+3 < len(rgba); i += 4 {
+		if rgba[i+3] < MIN_ALPHA {
+			continue
+		}
+		pixels = append(pixels, [3]float64{float64(rgba[i]), float64(rgba[i+1]), float64(rgba[i+2])})
+	}
+	if len(pixels) == 0 || n <= 0 {
+		return nil
+	}
+
+	centers := medianCut(pixels, n)
+	counts := make([]int, len(centers))
+	for iter := 0; iter < KMEANS_ITERATIONS; iter++ {
+		sums := make([][3]float64, len(centers))
+		for i := range counts {
+			counts[i] = 0
+		}
+		for _, p := range pixels {
+			best, bestDist := 0, math.MaxFloat64
+			for j, c := range centers {
+				d := (p[0]-c[0])*(p[0]-c[0]) + (p[1]-c[1])*(p[1]-c[1]) + (p[2]-c[2])*(p[2]-c[2])
+				if d < bestDist {
+					best, bestDist = j, d
+				}
+			}
+			counts[best]++
+			for k := 0; k < 3; k++ {
+				sums[best][k] += p[k]
+			}
+		}
+		for j := range centers {
+			if counts[j] > 0 {
+				for k := 0; k < 3; k++ {
+					centers[j][k] = sums[j][k] / float64(counts[j])
+				}
+			}
+		}
+	}
+
+	colors := []Color{}
+	for j, c := range centers {
+		if counts[j] == 0 {
+			continue
+		}
+		r, g, b := uint8(math.Round(c[0])), uint8(math.Round(c[1])), uint8(math.Round(c[2]))
+		colors = append(colors, Color{
+			Hex:   fmt.Sprintf("#%02x%02x%02x", r, g, b),
+			Ratio: math.Round(float64(counts[j])/float64(len(pixels))*1000) / 1000,
+			Text:  TextColor(r, g, b),
+		})
+	}
+	sort.SliceStable(colors, func(i, j int) bool {
+		return colors[i].Ratio > colors[j].Ratio
+	})
+	return colors
+}
+
+// medianCut splits pixels into at most n boxes along their widest channel, returning the mean color of each
+func medianCut(pixels [][3]float64, n int) [][3]float64 {
+	boxes := [][][3]float64{pixels}
+	for len(boxes) < n {
+		// split the most populated box that still has more than one color
+		index, channel := -1, 0
+		for i, box := range boxes {
+			if index >= 0 && len(box) <= len(boxes[index]) {
+				continue
+			}
+			if c, width := widestChannel(box); width > 0 {
+				index, channel = i, c
+			}
+		}
+		if index < 0 {
+			break
+		}
+		box := boxes[index]
+		sort.Slice(box, func(i, j int) bool {
+			return box[i][channel] < box[j][channel]
+		})
+		boxes[index] = box[:len(box)/2]
+		boxes = append(boxes, box[len(box)/2:])
+	}
+
+	centers := make([][3]float64, len(boxes))
+	for i, box := range boxes {
+		for _, p := range box {
+			for k := 0; k < 3; k++ {
+				centers[i][k] += p[k]
+			}
+		}
+		for k := 0; k < 3; k++ {
+			centers[i][k] /= float64(len(box))
+		}
+	}
+	return centers
+}
+
+func widestChannel(box [][3]float64) (int, float64) {
+	channel, width := 0, 0.0
+	for k := 0; k < 3; k++ {
+		lo, hi := math.MaxFloat64, -1.0
+		for _, p := range box {
+			lo = math.Min(lo, p[k])
+			hi = math.Max(hi, p[k])
+		}
+		if hi-lo > width {
+			channel, width = k, hi-lo
+		}
+	}
+	return channel, width
+}
+
+// TextColor picks white or black text for a background, by the WCAG contrast ratio
+func TextColor(r, g, b uint8) string {
+	l := 0.2126*linear(r) + 0.7152*linear(g) + 0.0722*linear(b)
+	if 1.05/(l+0.05) >= (l+0.05)/0.05 {
+		return "#ffffff"
+	}
+	return "#000000"
+}
+
+func linear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.03928 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
@@ -0,0 +1,102 @@
+package phash
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// Hash is a 64-bit perceptual hash, similar images differ in few bits
+type Hash uint64
+
+const (
+	PHASH_SIZE   = 32 // pHash takes 32x32 gray pixels
+	DHASH_WIDTH  = 9  // dHash takes 9x8 gray pixels
+	DHASH_HEIGHT = 8
+)
+
+// PHash hashes 32x32 gray pixels by the signs of their low DCT frequencies against the median
+func PHash(gray []byte) Hash {
+	// 2D DCT-II, only the top-left 8x8 is needed
+	const n = PHASH_SIZE
+	var cos [8][n]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	coeffs := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					sum += float64(gray[y*n+x]) * cos[u][x] * cos[v][y]
+				}
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	// the DC term is the mean brightness, it's left out of the median
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var h Hash
+	for i, c := range coeffs {
+		if c > median {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+// DHash hashes 9x8 gray pixels by whether each pixel is brighter than its right neighbour
+func DHash(gray []byte) Hash {
+	var h Hash
+	for y := 0; y < DHASH_HEIGHT; y++ {
+		for x := 0; x < DHASH_WIDTH-1; x++ {
+			if gray[y*DHASH_WIDTH+x] > gray[y*DHASH_WIDTH+x+1] {
+				h |= 1 << uint(y*(DHASH_WIDTH-1)+x)
+			}
+		}
+	}
+	return h
+}
+
+// Distance is the number of differing bits
+func Distance(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// SequenceDistance is the mean distance between frames of two fingerprints at the same position, false if either is empty
+func SequenceDistance(a, b []Hash) (int, bool) {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	if n == 0 {
+		return 0, false
+	}
+	sum := 0
+	for i := 0; i < n; i++ {
+		sum += Distance(a[i], b[i])
+	}
+	return int(math.Round(float64(sum) / float64(n))), true
+}
+
+// MarshalText encodes the hash as 16 hex digits, since JSON numbers can't hold 64 bits
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%016x", uint64(h))), nil
+}
+
+func (h *Hash) UnmarshalText(b []byte) error {
+	v, e := strconv.ParseUint(string(b), 16, 64)
+	if e != nil {
+		return e
+	}
+	*h = Hash(v)
+	return nil
+}
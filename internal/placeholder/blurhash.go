@@ -0,0 +1,88 @@
+package placeholder
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// BlurHash encodes w×h RGBA pixels into a BlurHash of xComponents×yComponents, both within 1-9
+func BlurHash(xComponents, yComponents, w, h int, rgba []byte) string {
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var r, g, b float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					p := (y*w + x) * 4
+					r += basis * sRGBToLinear(rgba[p])
+					g += basis * sRGBToLinear(rgba[p+1])
+					b += basis * sRGBToLinear(rgba[p+2])
+				}
+			}
+			scale := normalisation / float64(w*h)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	b := new(strings.Builder)
+	encode83(b, (xComponents-1)+(yComponents-1)*9, 1)
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, f := range factors[1:] {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		encode83(b, quantisedMax, 1)
+	} else {
+		encode83(b, 0, 1)
+	}
+
+	dc := factors[0]
+	encode83(b, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+	for _, f := range factors[1:] {
+		encode83(b, quantiseAC(f[0], maxValue)*19*19+quantiseAC(f[1], maxValue)*19+quantiseAC(f[2], maxValue), 2)
+	}
+	return b.String()
+}
+
+func quantiseAC(v, maxValue float64) int {
+	return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+}
+
+func encode83(b *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := value / int(math.Pow(83, float64(length-i))) % 83
+		b.WriteByte(base83Chars[digit])
+	}
+}
+
+func sRGBToLinear(c byte) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(f float64) int {
+	v := math.Max(0, math.Min(1, f))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,136 @@
+package placeholder
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+)
+
+// ThumbHash encodes w×h RGBA pixels, both at most 100, into a base64 ThumbHash
+func ThumbHash(w, h int, rgba []byte) (string, error) {
+	if w > 100 || h > 100 {
+		return "", errors.New("thumbhash needs an image within 100x100")
+	}
+	n := w * h
+
+	// average color
+	var avgR, avgG, avgB, avgA float64
+	for i := 0; i < n; i++ {
+		alpha := float64(rgba[i*4+3]) / 255
+		avgR += alpha / 255 * float64(rgba[i*4])
+		avgG += alpha / 255 * float64(rgba[i*4+1])
+		avgB += alpha / 255 * float64(rgba[i*4+2])
+		avgA += alpha
+	}
+	if avgA > 0 {
+		avgR /= avgA
+		avgG /= avgA
+		avgB /= avgA
+	}
+	hasAlpha := avgA < float64(n)
+	lLimit := 7.0
+	if hasAlpha {
+		// fewer luminance bits if there's alpha
+		lLimit = 5
+	}
+	maxWH := float64(maxInt(w, h))
+	lx := maxInt(1, int(round(lLimit*float64(w)/maxWH)))
+	ly := maxInt(1, int(round(lLimit*float64(h)/maxWH)))
+
+	// RGBA to LPQA, composited atop the average color
+	l, p, q, a := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := 0; i < n; i++ {
+		alpha := float64(rgba[i*4+3]) / 255
+		r := avgR*(1-alpha) + alpha/255*float64(rgba[i*4])
+		g := avgG*(1-alpha) + alpha/255*float64(rgba[i*4+1])
+		b := avgB*(1-alpha) + alpha/255*float64(rgba[i*4+2])
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	// DCT into the constant DC and normalized AC terms
+	encodeChannel := func(channel []float64, nx, ny int) (float64, []float64, float64) {
+		var dc, scale float64
+		ac := []float64{}
+		fx := make([]float64, w)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				var f float64
+				for x := 0; x < w; x++ {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				for y := 0; y < h; y++ {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < w; x++ {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(n)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+	lDC, lAC, lScale := encodeChannel(l, maxInt(3, lx), maxInt(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+
+	// constants
+	isLandscape := w > h
+	header24 := int(round(63*lDC)) | int(round(31.5+31.5*pDC))<<6 | int(round(31.5+31.5*qDC))<<12 | int(round(31*lScale))<<18
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	header16 := int(round(63*pScale))<<3 | int(round(63*qScale))<<9
+	if isLandscape {
+		header16 |= ly | 1<<15
+	} else {
+		header16 |= lx
+	}
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	acs := [][]float64{lAC, pAC, qAC}
+	if hasAlpha {
+		aDC, aAC, aScale := encodeChannel(a, 5, 5)
+		hash = append(hash, byte(int(round(15*aDC))|int(round(15*aScale))<<4))
+		acs = append(acs, aAC)
+	}
+
+	// varying factors, 4 bits each
+	acStart := len(hash)
+	acIndex := 0
+	for _, ac := range acs {
+		for _, f := range ac {
+			i := acStart + acIndex>>1
+			for len(hash) <= i {
+				hash = append(hash, 0)
+			}
+			hash[i] |= byte(int(round(15*f)) << ((acIndex & 1) << 2))
+			acIndex++
+		}
+	}
+	return base64.StdEncoding.EncodeToString(hash), nil
+}
+
+// round rounds half up, the same as JavaScript's Math.round
+func round(f float64) float64 {
+	return math.Floor(f + 0.5)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,163 @@
+package raster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/StevenZack/tools/cmdToolkit"
+)
+
+// Options are how documents and vector images are rasterized
+type Options struct {
+	DPI     int `json:"dpi"`     // 150 by default
+	MaxSize int `json:"maxSize"` // longest side in pixels, the DPI is lowered to fit, 4096 by default
+}
+
+const (
+	DEFAULT_DPI      = 150
+	DEFAULT_MAX_SIZE = 4096
+	MAX_DPI          = 1200
+	MAX_MAX_SIZE     = 16384
+
+	PDF_POINTS_PER_INCH = 72
+	CSS_PIXELS_PER_INCH = 96
+)
+
+var (
+	ErrMissingTool = errors.New("rasterizer not installed")
+
+	pdfPagesRegex    = regexp.MustCompile(`(?m)^Pages:\s+(\d+)`)
+	pdfPageSizeRegex = regexp.MustCompile(`(?m)^Page\s+\d+\s+size:\s+([\d.]+) x ([\d.]+) pts`)
+	pdfPageRotRegex  = regexp.MustCompile(`(?m)^Page\s+\d+\s+rot:\s+(-?\d+)`)
+)
+
+func (o Options) Validate() error {
+	if o.DPI < 0 || o.DPI > MAX_DPI {
+		return fmt.Errorf("dpi must be within 1-%d", MAX_DPI)
+	}
+	if o.MaxSize < 0 || o.MaxSize > MAX_MAX_SIZE {
+		return fmt.Errorf("maxSize must be within 1-%d", MAX_MAX_SIZE)
+	}
+	return nil
+}
+
+func (o Options) WithDefaults() Options {
+	if o.DPI == 0 {
+		o.DPI = DEFAULT_DPI
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = DEFAULT_MAX_SIZE
+	}
+	return o
+}
+
+// fit returns the pixel size of w×h inches at the DPI, scaled down to MaxSize
+func (o Options) fit(w, h float64) (int, int) {
+	pw, ph := w*float64(o.DPI), h*float64(o.DPI)
+	if long := math.Max(pw, ph); long > float64(o.MaxSize) {
+		pw, ph = pw*float64(o.MaxSize)/long, ph*float64(o.MaxSize)/long
+	}
+	return int(math.Max(1, math.Round(pw))), int(math.Max(1, math.Round(ph)))
+}
+
+// ProbePDF returns the page count of a PDF, and the displayed size of page in points, which is 0 if page is past the last one
+//
+// pdfinfo a.pdf && pdfinfo -f 3 -l 3 a.pdf
+func ProbePDF(filename string, page int) (int, float64, float64, error) {
+	if _, e := exec.LookPath("pdfinfo"); e != nil {
+		return 0, 0, 0, fmt.Errorf("%w: pdfinfo", ErrMissingTool)
+	}
+	output, e := cmdToolkit.Run("pdfinfo", filename)
+	if e != nil {
+		return 0, 0, 0, fmt.Errorf("%w: %s", e, output)
+	}
+	m := pdfPagesRegex.FindStringSubmatch(output)
+	if m == nil {
+		return 0, 0, 0, errors.New("page count not found in pdfinfo output")
+	}
+	pages, _ := strconv.Atoi(m[1])
+	// pdfinfo fails with a wrong page range
+	if page > pages {
+		return pages, 0, 0, nil
+	}
+	output, e = cmdToolkit.Run("pdfinfo", "-f", strconv.Itoa(page), "-l", strconv.Itoa(page), filename)
+	if e != nil {
+		return 0, 0, 0, fmt.Errorf("%w: %s", e, output)
+	}
+	w, h, e := parsePageSize(output)
+	if e != nil {
+		return 0, 0, 0, e
+	}
+	return pages, w, h, nil
+}
+
+// parsePageSize reads the size of a page from pdfinfo output, swapped for pages rotated by a quarter turn,
+// since the MediaBox is given unrotated
+//
+// Page    3 size: 612 x 792 pts (letter)
+// Page    3 rot:  90
+func parsePageSize(output string) (float64, float64, error) {
+	m := pdfPageSizeRegex.FindStringSubmatch(output)
+	if m == nil {
+		return 0, 0, errors.New("page size not found in pdfinfo output")
+	}
+	w, _ := strconv.ParseFloat(m[1], 64)
+	h, _ := strconv.ParseFloat(m[2], 64)
+	if m = pdfPageRotRegex.FindStringSubmatch(output); m != nil {
+		rot, _ := strconv.Atoi(m[1])
+		if rot%180 != 0 {
+			w, h = h, w
+		}
+	}
+	return w, h, nil
+}
+
+// RasterizePDF renders a page of a PDF displayed w×h points into a PNG
+//
+// pdftoppm -f 3 -l 3 -scale-to-x 1275 -scale-to-y 1650 -png -singlefile a.pdf a.page
+func RasterizePDF(dst, filename string, page int, w, h float64, opt Options) error {
+	if _, e := exec.LookPath("pdftoppm"); e != nil {
+		return fmt.Errorf("%w: pdftoppm", ErrMissingTool)
+	}
+	pw, ph := opt.fit(w/PDF_POINTS_PER_INCH, h/PDF_POINTS_PER_INCH)
+	// pdftoppm appends the extension
+	root := strings.TrimSuffix(dst, ".png")
+	output, e := cmdToolkit.Run("pdftoppm", "-f", strconv.Itoa(page), "-l", strconv.Itoa(page), "-scale-to-x", strconv.Itoa(pw), "-scale-to-y", strconv.Itoa(ph), "-png", "-singlefile", filename, root)
+	if e != nil {
+		return fmt.Errorf("%w: %s", e, output)
+	}
+	return nil
+}
+
+// RasterizeSVG renders an SVG into a PNG.
+// The SVG is piped in, so it can't reference files next to it, like other uploads
+//
+// rsvg-convert -w 1024 -h 512 -f png -o a.png < a.svg
+func RasterizeSVG(dst, filename string, opt Options) error {
+	if _, e := exec.LookPath("rsvg-convert"); e != nil {
+		return fmt.Errorf("%w: rsvg-convert", ErrMissingTool)
+	}
+	b, e := os.ReadFile(filename)
+	if e != nil {
+		return e
+	}
+	w, h, e := svgSize(b)
+	if e != nil {
+		return e
+	}
+	pw, ph := opt.fit(w/CSS_PIXELS_PER_INCH, h/CSS_PIXELS_PER_INCH)
+	cmd := exec.Command("rsvg-convert", "-w", strconv.Itoa(pw), "-h", strconv.Itoa(ph), "-f", "png", "-o", dst)
+	cmd.Stdin = bytes.NewReader(b)
+	output, e := cmd.CombinedOutput()
+	if e != nil {
+		return fmt.Errorf("%w: %s", e, output)
+	}
+	return nil
+}
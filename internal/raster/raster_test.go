@@ -0,0 +1,49 @@
+package raster
+
+import "testing"
+
+func TestParsePageSize(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		w, h   float64
+	}{
+		{"unrotated", "Page    1 size: 612 x 792 pts (letter)\nPage    1 rot:  0\n", 612, 792},
+		{"quarter turn", "Page    3 size: 612 x 792 pts (letter)\nPage    3 rot:  90\n", 792, 612},
+		{"three quarters", "Page    3 size: 595.276 x 841.89 pts (A4)\nPage    3 rot:  270\n", 841.89, 595.276},
+		{"half turn", "Page   12 size: 612 x 792 pts (letter)\nPage   12 rot:  180\n", 612, 792},
+		{"without rotation", "Pages:          1\nPage    1 size: 200 x 100 pts\n", 200, 100},
+	}
+	for _, c := range cases {
+		w, h, e := parsePageSize(c.output)
+		if e != nil {
+			t.Errorf("%s: %v", c.name, e)
+			continue
+		}
+		if w != c.w || h != c.h {
+			t.Errorf("%s: got %v×%v, want %v×%v", c.name, w, h, c.w, c.h)
+		}
+	}
+	if _, _, e := parsePageSize("Pages:          1\n"); e == nil {
+		t.Error("missing size: got no error")
+	}
+}
+
+func TestFit(t *testing.T) {
+	cases := []struct {
+		name   string
+		opt    Options
+		w, h   float64 // inches
+		pw, ph int
+	}{
+		{"at the DPI", Options{DPI: 150, MaxSize: 4096}, 8.5, 11, 1275, 1650},
+		{"scaled down to MaxSize", Options{DPI: 600, MaxSize: 4096}, 8.5, 11, 3165, 4096},
+		{"at least a pixel", Options{DPI: 72, MaxSize: 4096}, 100, 0.001, 4096, 1},
+	}
+	for _, c := range cases {
+		pw, ph := c.opt.fit(c.w, c.h)
+		if pw != c.pw || ph != c.ph {
+			t.Errorf("%s: got %d×%d, want %d×%d", c.name, pw, ph, c.pw, c.ph)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package raster
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// replaced elements without a size are 300x150 in browsers
+	DEFAULT_SVG_WIDTH  = 300
+	DEFAULT_SVG_HEIGHT = 150
+)
+
+var (
+	// CSS pixels of each unit
+	svgUnits = map[string]float64{
+		"":   1,
+		"px": 1,
+		"pt": 96.0 / 72,
+		"pc": 16,
+		"mm": 96 / 25.4,
+		"cm": 96 / 2.54,
+		"in": 96,
+		"em": 16,
+		"ex": 8,
+	}
+)
+
+// svgSize returns the size of an SVG in CSS pixels, from the width, height and viewBox of its root
+func svgSize(b []byte) (float64, float64, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	for {
+		token, e := d.Token()
+		if e != nil {
+			return 0, 0, errors.New("no svg element found")
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "svg" {
+			return 0, 0, errors.New("root element isn't svg")
+		}
+		var w, h, vw, vh float64
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "width":
+				w = svgLength(attr.Value)
+			case "height":
+				h = svgLength(attr.Value)
+			case "viewBox":
+				fields := strings.FieldsFunc(attr.Value, func(r rune) bool { return r == ' ' || r == ',' })
+				if len(fields) == 4 {
+					vw, _ = strconv.ParseFloat(fields[2], 64)
+					vh, _ = strconv.ParseFloat(fields[3], 64)
+				}
+			}
+		}
+		switch {
+		case w > 0 && h > 0:
+		case vw > 0 && vh > 0:
+			// the viewBox gives the missing side by its aspect ratio
+			switch {
+			case w > 0:
+				h = w * vh / vw
+			case h > 0:
+				w = h * vw / vh
+			default:
+				w, h = vw, vh
+			}
+		default:
+			w, h = DEFAULT_SVG_WIDTH, DEFAULT_SVG_HEIGHT
+		}
+		return w, h, nil
+	}
+}
+
+// svgLength converts a length to CSS pixels, 0 for percentages and invalid lengths
+func svgLength(s string) float64 {
+	s = strings.TrimSpace(s)
+	i := len(s)
+	for i > 0 && (s[i-1] < '0' || s[i-1] > '9') && s[i-1] != '.' {
+		i--
+	}
+	unit, ok := svgUnits[strings.ToLower(s[i:])]
+	if !ok {
+		return 0
+	}
+	v, e := strconv.ParseFloat(s[:i], 64)
+	if e != nil || v <= 0 {
+		return 0
+	}
+	return v * unit
+}
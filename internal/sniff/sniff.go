@@ -0,0 +1,193 @@
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+)
+
+// Type is a media type recognized by its magic bytes
+type Type struct {
+	Mime     string
+	Ext      string
+	Demuxers []string // ffprobe format names the content may be read as
+}
+
+const (
+	HEADER_SIZE = 512
+)
+
+var (
+	JPEG      = &Type{"image/jpeg", ".jpg", []string{"jpeg_pipe", "image2"}}
+	PNG       = &Type{"image/png", ".png", []string{"png_pipe", "apng", "image2"}}
+	GIF       = &Type{"image/gif", ".gif", []string{"gif"}}
+	WEBP      = &Type{"image/webp", ".webp", []string{"webp_pipe", "image2"}}
+	BMP       = &Type{"image/bmp", ".bmp", []string{"bmp_pipe", "image2"}}
+	TIFF      = &Type{"image/tiff", ".tiff", []string{"tiff_pipe", "image2"}}
+	JXL       = &Type{"image/jxl", ".jxl", []string{"jpegxl_pipe", "jpegxl_anim", "image2"}}
+	AVIF      = &Type{"image/avif", ".avif", []string{"mov"}}
+	HEIC      = &Type{"image/heic", ".heic", []string{"mov"}}
+	HEIF      = &Type{"image/heif", ".heif", []string{"mov"}}
+	MP4       = &Type{"video/mp4", ".mp4", []string{"mov"}}
+	QUICKTIME = &Type{"video/quicktime", ".mov", []string{"mov"}}
+	THREE_GP  = &Type{"video/3gpp", ".3gp", []string{"mov"}}
+	WEBM      = &Type{"video/webm", ".webm", []string{"matroska", "webm"}}
+	MATROSKA  = &Type{"video/x-matroska", ".mkv", []string{"matroska"}}
+	AVI       = &Type{"video/x-msvideo", ".avi", []string{"avi"}}
+	FLV       = &Type{"video/x-flv", ".flv", []string{"flv"}}
+	MPEG_TS   = &Type{"video/mp2t", ".ts", []string{"mpegts"}}
+	M2TS      = &Type{"video/mp2t", ".m2ts", []string{"mpegts"}} // 192-byte packets of AVCHD camcorders, a 4-byte timecode before each
+	MPEG_PS   = &Type{"video/mpeg", ".mpg", []string{"mpeg"}}
+	OGG       = &Type{"video/ogg", ".ogv", []string{"ogg"}}
+	ASF       = &Type{"video/x-ms-asf", ".wmv", []string{"asf"}}
+
+	// documents are read by their rasterizers, not ffmpeg
+	PDF = &Type{"application/pdf", ".pdf", nil}
+	SVG = &Type{"image/svg+xml", ".svg", nil}
+)
+
+// Detect returns the type of content starting with b, nil if it's not a supported image or video
+func Detect(b []byte) *Type {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return GIF
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return WEBP
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "AVI ":
+		return AVI
+	case bytes.HasPrefix(b, []byte("BM")) && len(b) >= 14:
+		return BMP
+	case bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")):
+		return TIFF
+	case bytes.HasPrefix(b, []byte{0xFF, 0x0A}), bytes.HasPrefix(b, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")):
+		return JXL
+	case len(b) >= 12 && string(b[4:8]) == "ftyp":
+		return detectISOBMFF(b)
+	case len(b) >= 8 && (string(b[4:8]) == "moov" || string(b[4:8]) == "mdat" || string(b[4:8]) == "wide" || string(b[4:8]) == "free"):
+		// QuickTime files predating ftyp
+		return QUICKTIME
+	case bytes.HasPrefix(b, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		if bytes.Contains(b, []byte("webm")) {
+			return WEBM
+		}
+		return MATROSKA
+	case bytes.HasPrefix(b, []byte("FLV\x01")):
+		return FLV
+	case len(b) > 188 && b[0] == 0x47 && b[188] == 0x47:
+		return MPEG_TS
+	case len(b) > 196 && b[4] == 0x47 && b[196] == 0x47:
+		return M2TS
+	case bytes.HasPrefix(b, []byte{0x00, 0x00, 0x01, 0xBA}):
+		return MPEG_PS
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return detectOgg(b)
+	case bytes.HasPrefix(b, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}):
+		return ASF
+	case bytes.HasPrefix(b, []byte("%PDF-")):
+		return PDF
+	case isSVG(b):
+		return SVG
+	}
+	return nil
+}
+
+// isSVG reports whether the text starts with markup, an XML declaration, a comment or a doctype, with an svg element in it
+func isSVG(b []byte) bool {
+	text := bytes.TrimSpace(bytes.TrimPrefix(b, []byte("\xEF\xBB\xBF")))
+	return bytes.HasPrefix(text, []byte("<")) && bytes.Contains(bytes.ToLower(text), []byte("<svg"))
+}
+
+// detectISOBMFF tells MP4, QuickTime, HEIF and AVIF apart by the brands of the ftyp box
+func detectISOBMFF(b []byte) *Type {
+	size := int(binary.BigEndian.Uint32(b))
+	if size < 16 || size > len(b) {
+		size = len(b)
+	}
+	brands := []string{string(b[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(b[i:i+4]))
+	}
+	has := func(names ...string) bool {
+		for _, brand := range brands {
+			for _, name := range names {
+				if brand == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case has("avif", "avis"):
+		return AVIF
+	case has("heic", "heix", "heim", "heis", "hevc", "hevx"):
+		return HEIC
+	case has("mif1", "msf1"):
+		return HEIF
+	case brands[0] == "M4A " || brands[0] == "M4B " || brands[0] == "M4P ":
+		// audio and audiobooks of iTunes
+		return nil
+	case brands[0] == "qt  ":
+		return QUICKTIME
+	case strings.HasPrefix(brands[0], "3g"):
+		return THREE_GP
+	}
+	return MP4
+}
+
+// detectOgg checks the first packets of the streams for Theora, since Ogg mostly holds Vorbis or Opus audio only.
+// Every stream starts with a beginning-of-stream page before any other page
+func detectOgg(b []byte) *Type {
+	for len(b) >= 27 && string(b[:4]) == "OggS" && b[5]&0x02 != 0 {
+		segments := int(b[26])
+		if len(b) < 27+segments {
+			break
+		}
+		size := 0
+		for _, lacing := range b[27 : 27+segments] {
+			size += int(lacing)
+		}
+		packet := b[27+segments:]
+		if bytes.HasPrefix(packet, []byte("\x80theora")) {
+			return OGG
+		}
+		if len(packet) < size {
+			break
+		}
+		b = packet[size:]
+	}
+	return nil
+}
+
+// DetectFile detects the type of a file by its first bytes
+func DetectFile(filename string) (*Type, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	b := make([]byte, HEADER_SIZE)
+	n, e := io.ReadFull(f, b)
+	if e != nil && e != io.ErrUnexpectedEOF {
+		return nil, e
+	}
+	return Detect(b[:n]), nil
+}
+
+// Matches reports whether ffprobe's format_name, e.g. "mov,mp4,m4a,3gp,3g2,mj2", agrees with the type
+func (t *Type) Matches(formatName string) bool {
+	for _, name := range strings.Split(formatName, ",") {
+		for _, demuxer := range t.Demuxers {
+			if name == demuxer {
+				return true
+			}
+		}
+	}
+	return false
+}
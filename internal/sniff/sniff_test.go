@@ -0,0 +1,107 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// ftyp builds an ftyp box with its major and compatible brands
+func ftyp(major string, compatible ...string) []byte {
+	b := make([]byte, 16, 16+4*len(compatible))
+	binary.BigEndian.PutUint32(b, uint32(16+4*len(compatible)))
+	copy(b[4:], "ftyp"+major)
+	for _, brand := range compatible {
+		b = append(b, brand...)
+	}
+	return append(b, "\x00\x00\x00\x08free"...)
+}
+
+// oggPage builds a beginning-of-stream page holding packet
+func oggPage(packet string) []byte {
+	b := make([]byte, 27, 28+len(packet))
+	copy(b, "OggS")
+	b[5] = 0x02
+	b[26] = 1
+	b = append(b, byte(len(packet)))
+	return append(b, packet...)
+}
+
+// packets of transport streams, with a 4-byte timecode before each for M2TS
+func tsPackets(size, offset int) []byte {
+	b := make([]byte, size*3)
+	for i := 0; i < 3; i++ {
+		b[i*size+offset] = 0x47
+	}
+	return b
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		name string
+		b    []byte
+		want *Type
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0}, JPEG},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), PNG},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), WEBP},
+		{"avi", []byte("RIFF\x00\x00\x00\x00AVI LIST"), AVI},
+		{"mp4", ftyp("isom", "isom", "iso2", "avc1", "mp41"), MP4},
+		{"quicktime", ftyp("qt  ", "qt  "), QUICKTIME},
+		{"3gp", ftyp("3gp4", "isom", "3gp4"), THREE_GP},
+		{"avif", ftyp("avif", "mif1", "miaf"), AVIF},
+		{"avif compatible", ftyp("mif1", "avif"), AVIF},
+		{"heic", ftyp("heic", "mif1", "heic"), HEIC},
+		{"heif", ftyp("mif1", "mif1"), HEIF},
+		{"m4a", ftyp("M4A ", "M4A ", "mp42", "isom"), nil},
+		{"m4b", ftyp("M4B ", "M4B ", "mp42"), nil},
+		{"quicktime without ftyp", []byte("\x00\x00\x00\x08wide\x00\x00\x00\x00mdat"), QUICKTIME},
+		{"webm", []byte("\x1A\x45\xDF\xA3\x9F\x42\x82\x84webm"), WEBM},
+		{"matroska", []byte("\x1A\x45\xDF\xA3\x9F\x42\x82\x88matroska"), MATROSKA},
+		{"mpeg-ts", tsPackets(188, 0), MPEG_TS},
+		{"m2ts", tsPackets(192, 4), M2TS},
+		{"mpeg-ps", []byte{0x00, 0x00, 0x01, 0xBA, 0x44}, MPEG_PS},
+		{"ogg theora", oggPage("\x80theora\x03\x02\x01"), OGG},
+		{"ogg vorbis then theora", append(oggPage("\x01vorbis\x00\x00"), oggPage("\x80theora\x03\x02\x01")...), OGG},
+		{"ogg vorbis", oggPage("\x01vorbis\x00\x00\x00\x00"), nil},
+		{"ogg opus", oggPage("OpusHead\x01\x02"), nil},
+		{"pdf", []byte("%PDF-1.7\n"), PDF},
+		{"svg", []byte("\xEF\xBB\xBF<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), SVG},
+		{"html", []byte("<!DOCTYPE html><html></html>"), nil},
+		{"text", []byte("hello"), nil},
+		{"empty", nil, nil},
+	}
+	for _, c := range cases {
+		got := Detect(c.b)
+		if got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDetectISOBMFFBrokenSize(t *testing.T) {
+	// a box size beyond the header falls back to the bytes available
+	b := ftyp("mif1", "heic")
+	binary.BigEndian.PutUint32(b, 4096)
+	if got := Detect(b); got != HEIC {
+		t.Errorf("got %v, want %v", got, HEIC)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		t          *Type
+		formatName string
+		want       bool
+	}{
+		{MP4, "mov,mp4,m4a,3gp,3g2,mj2", true},
+		{WEBM, "matroska,webm", true},
+		{M2TS, "mpegts", true},
+		{JPEG, "png_pipe", false},
+		{OGG, "", false},
+	}
+	for _, c := range cases {
+		if got := c.t.Matches(c.formatName); got != c.want {
+			t.Errorf("%s matches %q: got %v, want %v", c.t.Ext, c.formatName, got, c.want)
+		}
+	}
+}
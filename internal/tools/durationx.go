@@ -2,6 +2,7 @@ package tools
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
@@ -32,3 +33,21 @@ func ParseDurationSeconds(s string) (int, error) {
 	}
 	return seconds, nil
 }
+
+// parse `80.5`, `01:20.5` or `00:01:20.480363` into seconds
+func ParseTimestamp(s string) (float64, error) {
+	ss := strings.Split(strings.TrimSpace(s), ":")
+	if len(ss) > 3 {
+		return 0, fmt.Errorf("Invalid timestamp format: %s", s)
+	}
+	var seconds float64
+	for _, v := range ss {
+		num, e := strconv.ParseFloat(v, 64)
+		// ParseFloat takes NaN and Inf too
+		if e != nil || num < 0 || math.IsNaN(num) || math.IsInf(num, 0) {
+			return 0, fmt.Errorf("Invalid timestamp format: %s", s)
+		}
+		seconds = seconds*60 + num
+	}
+	return seconds, nil
+}
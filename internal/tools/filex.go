@@ -1,30 +1,34 @@
 package tools
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"log"
 	"mime/multipart"
 	"os"
 )
 
-func ReadFileHeader(dst string, fh *multipart.FileHeader) error {
+// ReadFileHeader saves the upload to dst, returning the hex SHA-256 of its content
+func ReadFileHeader(dst string, fh *multipart.FileHeader) (string, error) {
 	fi, e := fh.Open()
 	if e != nil {
 		log.Println(e)
-		return e
+		return "", e
 	}
 	defer fi.Close()
 
 	fo, e := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if e != nil {
 		log.Println(e)
-		return e
+		return "", e
 	}
 	defer fo.Close()
-	_, e = io.Copy(fo, fi)
+	h := sha256.New()
+	_, e = io.Copy(io.MultiWriter(fo, h), fi)
 	if e != nil {
 		log.Println(e)
-		return e
+		return "", e
 	}
-	return e
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
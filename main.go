@@ -1,20 +1,20 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"mime"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/StevenZack/tools/cmdToolkit"
-	"github.com/StevenZack/tools/strToolkit"
 	"github.com/StevenZack/transcoder/internal/core"
+	"github.com/StevenZack/transcoder/internal/ffmpegx"
 	"github.com/StevenZack/transcoder/internal/gx"
+	"github.com/StevenZack/transcoder/internal/tools"
 	"github.com/StevenZack/transcoder/internal/vars"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -26,6 +26,7 @@ var (
 	jwtSecret = flag.String("jwt-secret", "", "Use JWT authentication")
 	origins   = flag.String("origins", "*", "Allowed origins, split by [,]")
 	port      = flag.Int("p", 80, "port")
+	profiles  = flag.String("profiles", "", "JSON file of extra output profiles")
 	upgrader  websocket.Upgrader
 )
 
@@ -42,6 +43,13 @@ func main() {
 		return
 	}
 	println(out)
+	if *profiles != "" {
+		e = core.LoadProfiles(*profiles)
+		if e != nil {
+			log.Println(e)
+			return
+		}
+	}
 
 	r := gin.Default()
 	corsConfig := cors.Config{
@@ -82,6 +90,8 @@ func main() {
 	}
 	api.DELETE("tasks/:id", authMiddleware, deleteTask)
 	api.GET("tasks/:id/ws", authMiddleware, ws)
+	api.POST("tasks/:id/cover", authMiddleware, postCover)
+	api.GET("tasks/:id/similar", authMiddleware, getSimilarTasks)
 
 	r.Static(core.PUBLIC_PREFIX, core.AppDir)
 
@@ -178,6 +188,8 @@ func deleteTask(c *gin.Context) {
 func getAllTasks(c *gin.Context) {
 	l := []core.Task{}
 	core.TaskMap.Range(func(key string, value core.Task) bool {
+		// EXIF is only shown to the owner
+		value.Metadata = nil
 		l = append(l, value)
 		return true
 	})
@@ -192,25 +204,75 @@ func postTasks(c *gin.Context) {
 		return
 	}
 
+	clip, e := ffmpegx.ParseClip(c.PostForm("start"), c.PostForm("end"), c.PostForm("duration"))
+	if e != nil {
+		gx.BadRequest(c, e.Error())
+		return
+	}
+	profile, e := core.GetProfile(c.PostForm("profile"))
+	if e != nil {
+		gx.BadRequest(c, e.Error())
+		return
+	}
+	opt := &core.Options{
+		Profile:  profile,
+		Clip:     clip,
+		Lossless: c.PostForm("lossless") == "true",
+	}
+	if crop := c.PostForm("crop"); crop != "" {
+		opt.Crop, e = ffmpegx.ParseRect(crop)
+		if e != nil {
+			gx.BadRequest(c, e.Error())
+			return
+		}
+	}
+	if page := c.PostForm("page"); page != "" {
+		opt.Page, e = strconv.Atoi(page)
+		if e != nil || opt.Page < 1 {
+			gx.BadRequest(c, "page must be a positive integer")
+			return
+		}
+	}
+	if cover := c.PostForm("cover"); cover != "" {
+		opt.Cover, e = ffmpegx.ParseCover(cover)
+		if e != nil {
+			gx.BadRequest(c, e.Error())
+			return
+		}
+	}
+
 	tasks := []core.Task{}
 	fhs := form.File["file"]
+	if c.PostForm("mode") == "concat" {
+		if clip != nil {
+			gx.BadRequest(c, "start/end/duration aren't supported in concat mode")
+			return
+		}
+		opt.Durations, e = core.ParseDurations(c.PostForm("durations"))
+		if e != nil {
+			gx.BadRequest(c, e.Error())
+			return
+		}
+		task, e := core.CreateConcatTask(fhs, getSub(c), opt)
+		if e != nil {
+			taskError(c, e)
+			return
+		}
+		core.TaskMap.Store(task.Id, *task)
+		tasks = append(tasks, *task)
+		c.JSON(200, gin.H{
+			"tasks": tasks,
+		})
+		return
+	}
 	for _, fh := range fhs {
-		mime := mime.TypeByExtension(filepath.Ext(fh.Filename))
-		mime = strToolkit.SubBefore(mime, "/", mime)
-		switch mime {
-		case "image", "video":
-			task, e := core.CreateTask(fh, getSub(c))
-			if e != nil {
-				log.Println(e)
-				gx.ServerError(c, e)
-				return
-			}
-			core.TaskMap.Store(task.Id, *task)
-			tasks = append(tasks, *task)
-		default:
-			gx.BadRequest(c, "Unsupported file type :", fh.Filename)
+		task, e := core.CreateTask(fh, getSub(c), opt)
+		if e != nil {
+			taskError(c, e)
 			return
 		}
+		core.TaskMap.Store(task.Id, *task)
+		tasks = append(tasks, *task)
 	}
 
 	c.JSON(200, gin.H{
@@ -229,9 +291,90 @@ func getTask(c *gin.Context) {
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
+	v.LoadProgress()
 
 	c.JSON(200, v)
 }
+
+// taskError responds with the status of an error creating a task
+func taskError(c *gin.Context, e error) {
+	log.Println(e)
+	switch {
+	case errors.Is(e, core.ErrUnsupportedMedia):
+		gx.UnsupportedMediaType(c, e.Error())
+	case errors.Is(e, core.ErrInvalidOptions):
+		gx.BadRequest(c, e.Error())
+	case errors.Is(e, core.ErrBlankMedia):
+		gx.UnprocessableEntity(c, e.Error())
+	default:
+		gx.ServerError(c, e)
+	}
+}
+
+// postCover regenerates the cover of a video task at `time`, relative to the clip if any
+func postCover(c *gin.Context) {
+	id := c.Param("id")
+	task, ok := core.TaskMap.Load(id)
+	if !ok {
+		gx.NotFound(c, id)
+		return
+	}
+	if task.User != getSub(c) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if task.CoverFilter == "" {
+		gx.BadRequest(c, "Cover of task ", id, " can't be regenerated")
+		return
+	}
+	at, e := tools.ParseTimestamp(c.PostForm("time"))
+	if e != nil {
+		gx.BadRequest(c, e.Error())
+		return
+	}
+	// the duration of short clips and streams may be unknown
+	if task.MediaInfo.DurationSeconds > 0 && at >= float64(task.MediaInfo.DurationSeconds) {
+		gx.BadRequest(c, "time exceeds the duration")
+		return
+	}
+
+	e = task.RegenerateCover(at)
+	if e != nil {
+		log.Println(e)
+		gx.ServerError(c, e)
+		return
+	}
+	core.TaskMap.Store(id, task)
+	c.JSON(200, task)
+}
+
+// getSimilarTasks lists the caller's tasks perceptually within `distance` bits of the task
+func getSimilarTasks(c *gin.Context) {
+	id := c.Param("id")
+	task, ok := core.TaskMap.Load(id)
+	if !ok {
+		gx.NotFound(c, id)
+		return
+	}
+	if task.User != getSub(c) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	distance := core.DEFAULT_SIMILAR_DISTANCE
+	if s := c.Query("distance"); s != "" {
+		var e error
+		distance, e = strconv.Atoi(s)
+		if e != nil || distance < 0 || distance > core.MAX_SIMILAR_DISTANCE {
+			gx.BadRequest(c, "distance must be within 0-", core.MAX_SIMILAR_DISTANCE)
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"tasks": core.FindSimilar(&task, distance),
+	})
+}
+
 func getSub(c *gin.Context) string {
 	return c.Value("sub").(string)
 }